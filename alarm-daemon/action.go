@@ -0,0 +1,213 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"sync"
+	"time"
+
+	messages "github.com/CaptainStandby/divera-monitor/proto"
+)
+
+// Action is a single step of switching the display on or off. msg is the
+// alarm the display is switched for, nil if there is none, e.g. for an
+// override.
+type Action interface {
+	fmt.Stringer
+	Run(ctx context.Context, msg *messages.Alarm) error
+}
+
+type timedAction struct {
+	Action
+	timeout time.Duration
+	// label names the action in metrics, its type and position in the list,
+	// e.g. "command 1". String may contain URLs and secrets.
+	label string
+}
+
+// actionList runs its actions either one after another, stopping at the first
+// failure, or all at once. Every action has its own timeout.
+type actionList struct {
+	name     string
+	actions  []timedAction
+	parallel bool
+}
+
+func (l *actionList) run(ctx context.Context, msg *messages.Alarm) error {
+	log.Printf("switching %s\n", l.name)
+
+	if !l.parallel {
+		for _, a := range l.actions {
+			if err := runAction(ctx, l.name, a, msg); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	var wg sync.WaitGroup
+	errs := make([]error, len(l.actions))
+	for i, a := range l.actions {
+		wg.Add(1)
+		go func(i int, a timedAction) {
+			defer wg.Done()
+			errs[i] = runAction(ctx, l.name, a, msg)
+		}(i, a)
+	}
+	wg.Wait()
+
+	return errors.Join(errs...)
+}
+
+func runAction(ctx context.Context, direction string, a timedAction, msg *messages.Alarm) error {
+	ctx, cancel := context.WithTimeout(ctx, a.timeout)
+	defer cancel()
+
+	start := time.Now()
+	err := a.Run(ctx, msg)
+	elapsed := time.Since(start)
+
+	result := "success"
+	if err != nil {
+		result = "failure"
+	}
+	actionDuration.WithLabelValues(direction, a.label, result).Observe(elapsed.Seconds())
+
+	if err != nil {
+		return fmt.Errorf("%s: %w", a, err)
+	}
+	log.Printf("%s finished after %s\n", a, elapsed.Round(time.Millisecond))
+	return nil
+}
+
+// duration is a time.Duration that is written as a string like "30s" in
+// configuration files.
+type duration time.Duration
+
+func (d *duration) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return fmt.Errorf("duration must be a string like \"30s\": %w", err)
+	}
+	v, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = duration(v)
+	return nil
+}
+
+// actionConfig describes one action. Which fields are used depends on Type.
+type actionConfig struct {
+	Type    string   `json:"type" yaml:"type"`
+	Timeout duration `json:"timeout" yaml:"timeout"`
+
+	// command
+	Command string   `json:"command" yaml:"command"`
+	Args    []string `json:"args" yaml:"args"`
+	Stdin   string   `json:"stdin" yaml:"stdin"`
+
+	// http
+	Method  string            `json:"method" yaml:"method"`
+	URL     string            `json:"url" yaml:"url"`
+	Headers map[string]string `json:"headers" yaml:"headers"`
+	Body    string            `json:"body" yaml:"body"`
+
+	// mqtt
+	Broker   string `json:"broker" yaml:"broker"`
+	Topic    string `json:"topic" yaml:"topic"`
+	Payload  string `json:"payload" yaml:"payload"`
+	QoS      byte   `json:"qos" yaml:"qos"`
+	Retain   bool   `json:"retain" yaml:"retain"`
+	Username string `json:"username" yaml:"username"`
+	Password string `json:"password" yaml:"password"`
+
+	// wol
+	MAC       string `json:"mac" yaml:"mac"`
+	Broadcast string `json:"broadcast" yaml:"broadcast"`
+}
+
+type actionListConfig struct {
+	Parallel bool           `json:"parallel" yaml:"parallel"`
+	Actions  []actionConfig `json:"actions" yaml:"actions"`
+}
+
+type actionsConfig struct {
+	On  actionListConfig `json:"on" yaml:"on"`
+	Off actionListConfig `json:"off" yaml:"off"`
+}
+
+func loadActionsConfig(path string) (*actionsConfig, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	config := &actionsConfig{}
+	if err := json.Unmarshal(data, config); err != nil {
+		return nil, fmt.Errorf("could not parse %s: %w", path, err)
+	}
+	return config, nil
+}
+
+func buildActionList(name string, config actionListConfig, defaultTimeout time.Duration) (*actionList, error) {
+	if len(config.Actions) == 0 {
+		return nil, fmt.Errorf("no %s actions configured", name)
+	}
+
+	list := &actionList{name: name, parallel: config.Parallel}
+	for i, c := range config.Actions {
+		a, err := buildAction(c)
+		if err != nil {
+			return nil, fmt.Errorf("%s action %d: %w", name, i+1, err)
+		}
+		timeout := time.Duration(c.Timeout)
+		if timeout <= 0 {
+			timeout = defaultTimeout
+		}
+		list.actions = append(list.actions, timedAction{Action: a, timeout: timeout, label: fmt.Sprintf("%s %d", c.Type, i+1)})
+	}
+	return list, nil
+}
+
+func buildAction(c actionConfig) (Action, error) {
+	switch c.Type {
+	case "command":
+		return newCommandAction(c.Command, c.Args, c.Stdin)
+
+	case "http":
+		if c.URL == "" {
+			return nil, errors.New("url is required")
+		}
+		method := c.Method
+		if method == "" {
+			method = "POST"
+		}
+		return &httpAction{method: method, url: c.URL, headers: c.Headers, body: c.Body}, nil
+
+	case "mqtt":
+		if c.Broker == "" || c.Topic == "" {
+			return nil, errors.New("broker and topic are required")
+		}
+		if c.QoS > 2 {
+			return nil, errors.New("qos must be 0, 1 or 2")
+		}
+		return &mqttAction{
+			broker:   c.Broker,
+			topic:    c.Topic,
+			payload:  c.Payload,
+			qos:      c.QoS,
+			retain:   c.Retain,
+			username: c.Username,
+			password: c.Password,
+		}, nil
+
+	case "wol":
+		return newWakeOnLANAction(c.MAC, c.Broadcast)
+	}
+
+	return nil, fmt.Errorf("unknown action type %q", c.Type)
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+
+	messages "github.com/CaptainStandby/divera-monitor/proto"
+	"google.golang.org/protobuf/encoding/protojson"
+)
+
+// commandAction runs an executable. The alarm is passed in ALARM_*
+// environment variables, to arguments that are templates like "{{.Title}}"
+// and, if configured with stdin: json, as JSON on stdin.
+type commandAction struct {
+	command string
+	args    []*template.Template
+	stdin   bool
+}
+
+// alarmFields are the alarm as seen by argument templates. All fields are
+// zero without an alarm.
+type alarmFields struct {
+	ID        int64
+	ForeignID string
+	Title     string
+	Text      string
+	Address   string
+	Lat       float64
+	Lng       float64
+	Priority  bool
+	Created   time.Time
+	Updated   time.Time
+}
+
+func newCommandAction(command string, args []string, stdin string) (*commandAction, error) {
+	if command == "" {
+		return nil, errors.New("command is required")
+	}
+	a := &commandAction{command: command}
+	switch stdin {
+	case "":
+	case "json":
+		a.stdin = true
+	default:
+		return nil, fmt.Errorf("stdin must be json, not %s", stdin)
+	}
+
+	for i, arg := range args {
+		t, err := template.New(fmt.Sprintf("arg %d", i+1)).Parse(arg)
+		if err != nil {
+			return nil, err
+		}
+		// unknown fields are only noticed when the template is executed
+		if err := t.Execute(io.Discard, alarmFields{}); err != nil {
+			return nil, err
+		}
+		a.args = append(a.args, t)
+	}
+	return a, nil
+}
+
+func (a *commandAction) String() string {
+	return fmt.Sprintf("command %s", a.command)
+}
+
+func (a *commandAction) Run(ctx context.Context, msg *messages.Alarm) error {
+	fields := newAlarmFields(msg)
+	args := make([]string, len(a.args))
+	for i, t := range a.args {
+		var b strings.Builder
+		if err := t.Execute(&b, fields); err != nil {
+			return err
+		}
+		args[i] = b.String()
+	}
+
+	var stdin io.Reader
+	if a.stdin && msg != nil {
+		data, err := protojson.Marshal(msg)
+		if err != nil {
+			return fmt.Errorf("protojson.Marshal: %w", err)
+		}
+		stdin = bytes.NewReader(data)
+	}
+
+	_, err := executeCommand(ctx, alarmEnv(msg), stdin, a.command, args...)
+	return err
+}
+
+func newAlarmFields(msg *messages.Alarm) alarmFields {
+	if msg == nil {
+		return alarmFields{}
+	}
+	f := alarmFields{
+		ID:        msg.Id,
+		ForeignID: msg.ForeignId,
+		Title:     msg.Title,
+		Text:      msg.Text,
+		Address:   msg.Address,
+		Lat:       msg.Position.GetLatitude(),
+		Lng:       msg.Position.GetLongitude(),
+		Priority:  msg.Priority,
+	}
+	if msg.Created != nil {
+		f.Created = toTime(msg.Created)
+	}
+	if msg.Updated != nil {
+		f.Updated = toTime(msg.Updated)
+	}
+	return f
+}
+
+// alarmEnv returns the ALARM_* environment variables for msg. Fields the
+// alarm does not have are left out.
+func alarmEnv(msg *messages.Alarm) []string {
+	if msg == nil {
+		return nil
+	}
+	f := newAlarmFields(msg)
+	env := []string{
+		"ALARM_ID=" + strconv.FormatInt(f.ID, 10),
+		"ALARM_FOREIGN_ID=" + f.ForeignID,
+		"ALARM_TITLE=" + f.Title,
+		"ALARM_TEXT=" + f.Text,
+		"ALARM_ADDRESS=" + f.Address,
+		"ALARM_PRIORITY=" + strconv.FormatBool(f.Priority),
+	}
+	if msg.Position != nil {
+		env = append(env,
+			"ALARM_LAT="+strconv.FormatFloat(f.Lat, 'f', -1, 64),
+			"ALARM_LNG="+strconv.FormatFloat(f.Lng, 'f', -1, 64))
+	}
+	if !f.Created.IsZero() {
+		env = append(env, "ALARM_CREATED="+f.Created.UTC().Format(time.RFC3339))
+	}
+	if !f.Updated.IsZero() {
+		env = append(env, "ALARM_UPDATED="+f.Updated.UTC().Format(time.RFC3339))
+	}
+	return env
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	messages "github.com/CaptainStandby/divera-monitor/proto"
+)
+
+// httpAction sends a request, e.g. to a smart plug or a home automation hub.
+// Any status other than 2xx is a failure.
+type httpAction struct {
+	method  string
+	url     string
+	headers map[string]string
+	body    string
+}
+
+func (a *httpAction) String() string {
+	return fmt.Sprintf("http %s %s", a.method, a.url)
+}
+
+func (a *httpAction) Run(ctx context.Context, _ *messages.Alarm) error {
+	req, err := http.NewRequestWithContext(ctx, a.method, a.url, strings.NewReader(a.body))
+	if err != nil {
+		return fmt.Errorf("http.NewRequest: %w", err)
+	}
+	for k, v := range a.headers {
+		req.Header.Set(k, v)
+	}
+
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	_, _ = io.Copy(io.Discard, res.Body)
+
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		return fmt.Errorf("unexpected status %s", res.Status)
+	}
+	return nil
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	messages "github.com/CaptainStandby/divera-monitor/proto"
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+)
+
+// mqttAction publishes a single message. It connects for every run, actions
+// are rare and this way a broker restart does not need to be handled.
+type mqttAction struct {
+	broker   string
+	topic    string
+	payload  string
+	qos      byte
+	retain   bool
+	username string
+	password string
+}
+
+func (a *mqttAction) String() string {
+	return fmt.Sprintf("mqtt %s %s", a.broker, a.topic)
+}
+
+func (a *mqttAction) Run(ctx context.Context, _ *messages.Alarm) error {
+	opts := mqtt.NewClientOptions().
+		AddBroker(a.broker).
+		SetClientID(fmt.Sprintf("alarm-daemon-%d", time.Now().UnixNano())).
+		SetUsername(a.username).
+		SetPassword(a.password).
+		SetAutoReconnect(false)
+
+	client := mqtt.NewClient(opts)
+	if err := waitToken(ctx, client.Connect()); err != nil {
+		return fmt.Errorf("connect: %w", err)
+	}
+	defer client.Disconnect(250)
+
+	if err := waitToken(ctx, client.Publish(a.topic, a.qos, a.retain, a.payload)); err != nil {
+		return fmt.Errorf("publish: %w", err)
+	}
+	return nil
+}
+
+func waitToken(ctx context.Context, token mqtt.Token) error {
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-token.Done():
+		return token.Error()
+	}
+}
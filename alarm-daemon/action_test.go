@@ -0,0 +1,168 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	messages "github.com/CaptainStandby/divera-monitor/proto"
+	"github.com/stretchr/testify/assert"
+)
+
+type fakeAction struct {
+	name  string
+	err   error
+	delay time.Duration
+
+	mu  *sync.Mutex
+	log *[]string
+}
+
+func (a *fakeAction) String() string {
+	return a.name
+}
+
+func (a *fakeAction) Run(ctx context.Context, _ *messages.Alarm) error {
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-time.After(a.delay):
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	*a.log = append(*a.log, a.name)
+	return a.err
+}
+
+func TestActionList(t *testing.T) {
+	var mu sync.Mutex
+	var ran []string
+	action := func(name string, err error, delay, timeout time.Duration) timedAction {
+		return timedAction{
+			Action:  &fakeAction{name: name, err: err, delay: delay, mu: &mu, log: &ran},
+			timeout: timeout,
+		}
+	}
+
+	tt := []struct {
+		name     string
+		parallel bool
+		actions  []timedAction
+		ran      []string
+		err      string
+	}{
+		{
+			name:    "in order",
+			actions: []timedAction{action("a", nil, 0, time.Second), action("b", nil, 0, time.Second)},
+			ran:     []string{"a", "b"},
+		},
+		{
+			name:    "in order stops at first failure",
+			actions: []timedAction{action("a", errors.New("broken"), 0, time.Second), action("b", nil, 0, time.Second)},
+			ran:     []string{"a"},
+			err:     "a: broken",
+		},
+		{
+			name:    "timeout per action",
+			actions: []timedAction{action("slow", nil, time.Second, 10*time.Millisecond), action("b", nil, 0, time.Second)},
+			ran:     nil,
+			err:     "slow: context deadline exceeded",
+		},
+		{
+			name:     "parallel runs all",
+			parallel: true,
+			actions:  []timedAction{action("a", errors.New("broken"), 0, time.Second), action("b", nil, 20*time.Millisecond, time.Second)},
+			ran:      []string{"a", "b"},
+			err:      "a: broken",
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			ran = nil
+			list := &actionList{name: "on", actions: tc.actions, parallel: tc.parallel}
+			err := list.run(context.Background(), nil)
+			if tc.err == "" {
+				assert.NoError(t, err)
+			} else {
+				assert.EqualError(t, err, tc.err)
+			}
+			assert.Equal(t, tc.ran, ran)
+		})
+	}
+}
+
+func TestHTTPAction(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer t0k3n" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		fmt.Fprint(w, "ok")
+	}))
+	defer server.Close()
+
+	a, err := buildAction(actionConfig{Type: "http", URL: server.URL, Headers: map[string]string{"Authorization": "Bearer t0k3n"}})
+	assert.NoError(t, err)
+	assert.NoError(t, a.Run(context.Background(), nil))
+
+	a, err = buildAction(actionConfig{Type: "http", URL: server.URL})
+	assert.NoError(t, err)
+	assert.EqualError(t, a.Run(context.Background(), nil), "unexpected status 401 Unauthorized")
+}
+
+func TestWakeOnLANPacket(t *testing.T) {
+	a, err := newWakeOnLANAction("01:23:45:67:89:ab", "")
+	assert.NoError(t, err)
+	assert.Equal(t, DEFAULT_WOL_BROADCAST, a.broadcast)
+
+	packet := a.magicPacket()
+	assert.Len(t, packet, 102)
+	assert.Equal(t, []byte{0xff, 0xff, 0xff, 0xff, 0xff, 0xff}, packet[:6])
+	assert.Equal(t, []byte{0x01, 0x23, 0x45, 0x67, 0x89, 0xab}, packet[96:])
+
+	_, err = newWakeOnLANAction("not a mac", "")
+	assert.Error(t, err)
+}
+
+func TestCommandAction(t *testing.T) {
+	out := filepath.Join(t.TempDir(), "out")
+	script := `printf '%s|%s|%s|%s|' "$ALARM_ID" "$ALARM_TITLE" "$ALARM_LAT" "$1" > ` + out + `; cat >> ` + out
+
+	a, err := buildAction(actionConfig{Type: "command", Command: "/bin/sh", Args: []string{"-c", script, "sh", "alarm {{.ID}}: {{.Title}}"}, Stdin: "json"})
+	assert.NoError(t, err)
+
+	msg := &messages.Alarm{Id: 42, Title: "Brand", Position: &messages.Alarm_LatLng{Latitude: 52.5, Longitude: 13.4}}
+	assert.NoError(t, a.Run(context.Background(), msg))
+	data, err := os.ReadFile(out)
+	assert.NoError(t, err)
+	args, stdin, _ := strings.Cut(string(data), "|{")
+	assert.Equal(t, "42|Brand|52.5|alarm 42: Brand", args)
+	assert.JSONEq(t, `{"id":"42","title":"Brand","position":{"latitude":52.5,"longitude":13.4}}`, "{"+stdin)
+
+	// without an alarm the variables are not set and stdin is empty
+	assert.NoError(t, a.Run(context.Background(), nil))
+	data, err = os.ReadFile(out)
+	assert.NoError(t, err)
+	assert.Equal(t, "|||alarm 0: |", string(data))
+}
+
+func TestCommandActionInvalid(t *testing.T) {
+	for _, c := range []actionConfig{
+		{Type: "command"},
+		{Type: "command", Command: "/bin/true", Args: []string{"{{.Title"}},
+		{Type: "command", Command: "/bin/true", Args: []string{"{{.Unknown}}"}},
+		{Type: "command", Command: "/bin/true", Stdin: "yaml"},
+	} {
+		_, err := buildAction(c)
+		assert.Error(t, err, c)
+	}
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net"
+
+	messages "github.com/CaptainStandby/divera-monitor/proto"
+)
+
+const DEFAULT_WOL_BROADCAST = "255.255.255.255:9"
+
+// wakeOnLANAction sends a magic packet to wake up a display or PC.
+type wakeOnLANAction struct {
+	mac       net.HardwareAddr
+	broadcast string
+}
+
+func newWakeOnLANAction(mac, broadcast string) (*wakeOnLANAction, error) {
+	hw, err := net.ParseMAC(mac)
+	if err != nil {
+		return nil, fmt.Errorf("invalid mac: %w", err)
+	}
+	if len(hw) != 6 {
+		return nil, fmt.Errorf("mac %s is not a 48 bit address", mac)
+	}
+	if broadcast == "" {
+		broadcast = DEFAULT_WOL_BROADCAST
+	}
+	return &wakeOnLANAction{mac: hw, broadcast: broadcast}, nil
+}
+
+func (a *wakeOnLANAction) String() string {
+	return fmt.Sprintf("wol %s", a.mac)
+}
+
+// magicPacket is 6 times 0xff followed by 16 repetitions of the MAC.
+func (a *wakeOnLANAction) magicPacket() []byte {
+	packet := bytes.Repeat([]byte{0xff}, 6)
+	return append(packet, bytes.Repeat(a.mac, 16)...)
+}
+
+func (a *wakeOnLANAction) Run(ctx context.Context, _ *messages.Alarm) error {
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "udp", a.broadcast)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	_, err = conn.Write(a.magicPacket())
+	return err
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"time"
+
+	messages "github.com/CaptainStandby/divera-monitor/proto"
+	"google.golang.org/protobuf/encoding/protojson"
+)
+
+const usage = `usage: alarm-daemon [command]
+
+Without a command the daemon is started.
+
+commands:
+  dry-run FILE...   show how the rules and the schedule decide on alarms
+                    received now, given as JSON files like in the history
+                    ("-" reads stdin)
+  override [-socket PATH] on [MINUTES] | off | extend MINUTES | snooze
+                    switch the display of the running daemon on (for the
+                    linger time or MINUTES), off, extend the current alarm
+                    or switch off until the next alarm; the socket defaults
+                    to OVERRIDE_SOCKET or ` + DEFAULT_OVERRIDE_SOCKET + `
+`
+
+// runCommand runs a subcommand and returns the exit code.
+func runCommand(args []string) int {
+	switch args[0] {
+	case "dry-run":
+		if len(args) < 2 {
+			fmt.Fprint(os.Stderr, usage)
+			return 2
+		}
+		cfg, err := loadConfig(os.Getenv("CONFIG_FILE"))
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		settings, err := cfg.watcherSettings()
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		if err := dryRun(&settings, args[1:], os.Stdin, os.Stdout); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		return 0
+
+	case "override":
+		req, socket, err := parseOverride(args[1:])
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%v\n\n%s", err, usage)
+			return 2
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), 2*time.Minute)
+		defer cancel()
+		message, err := sendOverride(ctx, socket, req)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		fmt.Println(message)
+		return 0
+
+	case "help", "-h", "--help":
+		fmt.Print(usage)
+		return 0
+	}
+
+	fmt.Fprintf(os.Stderr, "unknown command %s\n\n%s", args[0], usage)
+	return 2
+}
+
+// dryRun evaluates the rules for every alarm file without running actions.
+func dryRun(settings *watcherSettings, files []string, stdin io.Reader, out io.Writer) error {
+	for _, file := range files {
+		var data []byte
+		var err error
+		if file == "-" {
+			data, err = io.ReadAll(stdin)
+		} else {
+			data, err = os.ReadFile(file)
+		}
+		if err != nil {
+			return err
+		}
+
+		msg := &messages.Alarm{}
+		if err := protojson.Unmarshal(data, msg); err != nil {
+			return fmt.Errorf("%s is not a valid alarm: %w", file, err)
+		}
+		fmt.Fprintf(out, "%s: alarm %d %q %s\n", file, msg.Id, msg.Title, settings.evaluate(msg, time.Now()))
+	}
+	return nil
+}
+
+// parseOverride parses the arguments of the override command.
+func parseOverride(args []string) (overrideRequest, string, error) {
+	flags := flag.NewFlagSet("override", flag.ContinueOnError)
+	flags.SetOutput(io.Discard)
+	socket := flags.String("socket", os.Getenv("OVERRIDE_SOCKET"), "")
+	if err := flags.Parse(args); err != nil {
+		return overrideRequest{}, "", err
+	}
+	if *socket == "" {
+		*socket = DEFAULT_OVERRIDE_SOCKET
+	}
+
+	args = flags.Args()
+	if len(args) == 0 || len(args) > 2 {
+		return overrideRequest{}, "", errors.New("override needs on, off, extend or snooze")
+	}
+	req := overrideRequest{Action: args[0]}
+	if len(args) == 2 {
+		minutes, err := strconv.Atoi(args[1])
+		if err != nil {
+			return overrideRequest{}, "", fmt.Errorf("%q is not a number of minutes", args[1])
+		}
+		req.Minutes = minutes
+	}
+	return req, *socket, req.validate()
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	messages "github.com/CaptainStandby/divera-monitor/proto"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWatcherClosedAlarms(t *testing.T) {
+	w := newTestWatcher()
+	settings := watcherSettings{
+		lingerTime:    time.Hour,
+		switchOn:      w.action("on", nil),
+		switchOff:     w.action("off", nil),
+		offWhenClosed: true,
+	}
+	w.start(t, settings)
+
+	now := time.Now()
+	w.alarm(&messages.Alarm{Id: 1}, now.Add(-30*time.Minute))
+	assert.Equal(t, "on", <-w.switched)
+	w.alarm(&messages.Alarm{Id: 2}, now)
+	assert.Equal(t, "on", <-w.switched)
+
+	// alarm 1 is still open, the standby time is its own again
+	w.alarm(&messages.Alarm{Id: 2, Closed: true}, now.Add(time.Second))
+	assert.Eventually(t, func() bool {
+		return w.status.snapshot(time.Now()).Timer.StandbyTime.Equal(now.Truncate(time.Second).Add(30 * time.Minute))
+	}, time.Second, time.Millisecond)
+	w.alarm(&messages.Alarm{Id: 1, Archived: true}, now.Add(time.Second))
+	assert.Equal(t, "off", <-w.switched)
+	assert.False(t, w.status.snapshot(time.Now()).Timer.Active)
+
+	// deleted alarms end it as well, unknown ones are ignored
+	w.alarm(&messages.Alarm{Id: 2}, now.Add(2*time.Second))
+	assert.Equal(t, "on", <-w.switched)
+	w.pipeline <- delivery{alarm: &messages.Alarm{Id: 1, Deleted: true, Updated: &messages.Alarm_Timestamp{}}, received: time.Now()}
+	w.pipeline <- delivery{alarm: &messages.Alarm{Id: 2, Deleted: true, Updated: &messages.Alarm_Timestamp{}}, received: time.Now()}
+	assert.Equal(t, "off", <-w.switched)
+
+	// with a grace time, closing switches off later
+	settings.closedGrace = 20 * time.Millisecond
+	w.reload <- settings
+	w.alarm(&messages.Alarm{Id: 3}, time.Now())
+	assert.Equal(t, "on", <-w.switched)
+	start := time.Now()
+	w.alarm(&messages.Alarm{Id: 3, Closed: true}, time.Now().Add(time.Second))
+	assert.Equal(t, "off", <-w.switched)
+	assert.GreaterOrEqual(t, time.Since(start), 20*time.Millisecond)
+
+	// disabled, closing keeps the display on for the linger time but never
+	// switches it on or extends it
+	settings.offWhenClosed = false
+	w.reload <- settings
+	w.alarm(&messages.Alarm{Id: 4, Closed: true}, time.Now())
+	w.alarm(&messages.Alarm{Id: 5, Archived: true}, time.Now())
+	assert.ErrorIs(t, w.override(overrideExtend, 10).err, errNoActiveAlarm)
+	opened := time.Now()
+	w.alarm(&messages.Alarm{Id: 6}, opened)
+	assert.Equal(t, "on", <-w.switched)
+	w.alarm(&messages.Alarm{Id: 6, Closed: true}, opened.Add(time.Minute))
+	w.pipeline <- delivery{alarm: &messages.Alarm{Id: 6, Deleted: true, Updated: &messages.Alarm_Timestamp{}}, received: time.Now()}
+	// the duplicate is only taken once the deletion was handled
+	w.alarm(&messages.Alarm{Id: 6}, opened)
+	timer := w.status.snapshot(time.Now()).Timer
+	assert.True(t, timer.Active)
+	assert.True(t, opened.Truncate(time.Second).Add(time.Hour).Equal(timer.StandbyTime), "standby at %s", timer.StandbyTime)
+	assert.Empty(t, w.switched)
+}
@@ -0,0 +1,201 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// MAX_COMMAND_OUTPUT_LINES is how many lines of output a command result
+// keeps, the last ones are the interesting ones.
+const MAX_COMMAND_OUTPUT_LINES = 50
+
+// COMMAND_WAIT_DELAY is how long to wait for the output of a killed command
+// whose pipes are still held open, e.g. by a child that escaped the kill.
+const COMMAND_WAIT_DELAY = 5 * time.Second
+
+// commandResult describes a finished command. ExitCode is -1 if it was
+// killed by a signal or did not start.
+type commandResult struct {
+	Command   string       `json:"command"`
+	ExitCode  int          `json:"exit_code"`
+	Signal    string       `json:"signal,omitempty"`
+	Duration  string       `json:"duration"`
+	TimedOut  bool         `json:"timed_out,omitempty"`
+	Error     string       `json:"error,omitempty"`
+	Output    []outputLine `json:"output,omitempty"`
+	Truncated int          `json:"truncated,omitempty"`
+}
+
+type outputLine struct {
+	Stream string `json:"stream"`
+	Text   string `json:"text"`
+}
+
+// stdout returns the lines the command wrote to stdout.
+func (r *commandResult) stdout() []string {
+	var lines []string
+	for _, l := range r.Output {
+		if l.Stream == "stdout" {
+			lines = append(lines, l.Text)
+		}
+	}
+	return lines
+}
+
+// describe summarizes how the command ended, with the last line of stderr.
+func (r *commandResult) describe() string {
+	var d string
+	switch {
+	case r.Error != "":
+		d = r.Error
+	case r.TimedOut:
+		d = fmt.Sprintf("timed out after %s", r.Duration)
+	case r.Signal != "":
+		d = fmt.Sprintf("killed by signal %s", r.Signal)
+	default:
+		d = fmt.Sprintf("exit code %d", r.ExitCode)
+	}
+	for i := len(r.Output) - 1; i >= 0; i-- {
+		if r.Output[i].Stream == "stderr" {
+			return fmt.Sprintf("%s: %s", d, r.Output[i].Text)
+		}
+	}
+	return d
+}
+
+// commandRecorder collects the results of the commands run with a context
+// from withCommandRecorder, so they can be shown with the action that ran
+// them.
+type commandRecorder struct {
+	mu      sync.Mutex
+	results []commandResult
+}
+
+type commandRecorderKey struct{}
+
+func withCommandRecorder(ctx context.Context) (context.Context, *commandRecorder) {
+	r := &commandRecorder{}
+	return context.WithValue(ctx, commandRecorderKey{}, r), r
+}
+
+func (r *commandRecorder) add(result commandResult) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.results = append(r.results, result)
+}
+
+func (r *commandRecorder) list() []commandResult {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.results
+}
+
+// executeCommand runs command with env added to the environment of the
+// daemon. stdin may be nil. Every line of output is logged tagged with its
+// stream. When ctx is done the whole process group is killed, so children
+// like cec-client do not outlive the command. Children left running in the
+// background by a command that exits in time are left alone. Errors do not name the
+// command, the callers do.
+func executeCommand(ctx context.Context, env []string, stdin io.Reader, command string, args ...string) (commandResult, error) {
+	result := commandResult{Command: command, ExitCode: -1}
+	output := &commandOutput{name: filepath.Base(command), result: &result}
+	stdout := &lineWriter{stream: "stdout", output: output}
+	stderr := &lineWriter{stream: "stderr", output: output}
+
+	cmd := exec.CommandContext(ctx, command, args...)
+	if env != nil {
+		cmd.Env = append(os.Environ(), env...)
+	}
+	cmd.Stdin = stdin
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
+	cmd.WaitDelay = COMMAND_WAIT_DELAY
+	killProcessGroup(cmd)
+
+	start := time.Now()
+	err := cmd.Start()
+	if err == nil {
+		err = cmd.Wait()
+	}
+	stdout.flush()
+	stderr.flush()
+	result.Duration = time.Since(start).Round(time.Millisecond).String()
+
+	if cmd.ProcessState != nil {
+		result.ExitCode = cmd.ProcessState.ExitCode()
+		result.Signal = exitSignal(cmd.ProcessState)
+	} else if err != nil {
+		result.Error = err.Error()
+	}
+	result.TimedOut = errors.Is(ctx.Err(), context.DeadlineExceeded)
+
+	if r, ok := ctx.Value(commandRecorderKey{}).(*commandRecorder); ok {
+		r.add(result)
+	}
+
+	if err == nil && !result.TimedOut {
+		return result, nil
+	}
+	log.Printf("[%s] %s\n", output.name, result.describe())
+	if result.TimedOut {
+		return result, fmt.Errorf("%s: %w", result.describe(), ctx.Err())
+	}
+	return result, errors.New(result.describe())
+}
+
+// commandOutput logs the lines of both streams of a command and keeps the
+// last of them in its result.
+type commandOutput struct {
+	mu     sync.Mutex
+	name   string
+	result *commandResult
+}
+
+func (o *commandOutput) add(stream, line string) {
+	log.Printf("[%s %s] %s\n", o.name, stream, line)
+
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.result.Output = append(o.result.Output, outputLine{Stream: stream, Text: line})
+	if len(o.result.Output) > MAX_COMMAND_OUTPUT_LINES {
+		o.result.Output = o.result.Output[1:]
+		o.result.Truncated++
+	}
+}
+
+// lineWriter splits what is written to it into lines.
+type lineWriter struct {
+	stream string
+	output *commandOutput
+	buf    []byte
+}
+
+func (w *lineWriter) Write(p []byte) (int, error) {
+	w.buf = append(w.buf, p...)
+	for {
+		i := bytes.IndexByte(w.buf, '\n')
+		if i < 0 {
+			break
+		}
+		w.output.add(w.stream, string(bytes.TrimRight(w.buf[:i], "\r")))
+		w.buf = w.buf[i+1:]
+	}
+	return len(p), nil
+}
+
+// flush adds a last line that did not end with a newline.
+func (w *lineWriter) flush() {
+	if len(w.buf) > 0 {
+		w.output.add(w.stream, string(w.buf))
+		w.buf = nil
+	}
+}
@@ -0,0 +1,16 @@
+//go:build !unix
+
+package main
+
+import (
+	"os"
+	"os/exec"
+)
+
+// killProcessGroup leaves cmd alone, without process groups only the command
+// itself is killed.
+func killProcessGroup(cmd *exec.Cmd) {}
+
+func exitSignal(state *os.ProcessState) string {
+	return ""
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestExecuteCommand(t *testing.T) {
+	ctx, recorder := withCommandRecorder(context.Background())
+
+	result, err := executeCommand(ctx, nil, nil, "/bin/sh", "-c", "echo one; echo two >&2; printf three")
+	assert.NoError(t, err)
+	assert.Equal(t, 0, result.ExitCode)
+	// the order between the streams is not kept
+	assert.ElementsMatch(t, []outputLine{{"stdout", "one"}, {"stderr", "two"}, {"stdout", "three"}}, result.Output)
+	assert.Equal(t, []string{"one", "three"}, result.stdout())
+
+	result, err = executeCommand(ctx, nil, nil, "/bin/sh", "-c", "echo 'line 1: FOO: unbound variable' >&2; exit 3")
+	assert.EqualError(t, err, "exit code 3: line 1: FOO: unbound variable")
+	assert.Equal(t, 3, result.ExitCode)
+
+	result, err = executeCommand(ctx, nil, nil, "/does/not/exist")
+	assert.Error(t, err)
+	assert.NotEmpty(t, result.Error)
+
+	assert.Len(t, recorder.list(), 3)
+}
+
+func TestExecuteCommandOutputLimit(t *testing.T) {
+	result, err := executeCommand(context.Background(), nil, nil, "/bin/sh", "-c", fmt.Sprintf("seq 1 %d", MAX_COMMAND_OUTPUT_LINES+10))
+	assert.NoError(t, err)
+	assert.Len(t, result.Output, MAX_COMMAND_OUTPUT_LINES)
+	assert.Equal(t, 10, result.Truncated)
+	assert.Equal(t, "11", result.Output[0].Text)
+}
@@ -0,0 +1,53 @@
+//go:build unix
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestExecuteCommandSignal(t *testing.T) {
+	result, err := executeCommand(context.Background(), nil, nil, "/bin/sh", "-c", "kill -TERM $$")
+	assert.EqualError(t, err, "killed by signal terminated")
+	assert.Equal(t, -1, result.ExitCode)
+	assert.Equal(t, "terminated", result.Signal)
+}
+
+func TestExecuteCommandTimeout(t *testing.T) {
+	// the child would keep running without killing the process group
+	pidFile := filepath.Join(t.TempDir(), "pid")
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	result, err := executeCommand(ctx, nil, nil, "/bin/sh", "-c", "sleep 30 & echo $! > "+pidFile+"; wait")
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.True(t, result.TimedOut)
+	assert.Equal(t, "killed", result.Signal)
+	assert.Less(t, time.Since(start), 5*time.Second)
+
+	data, err := os.ReadFile(pidFile)
+	assert.NoError(t, err)
+	pid, err := strconv.Atoi(strings.TrimSpace(string(data)))
+	assert.NoError(t, err)
+	// the killed child is not reaped if the init of the container does not
+	// do it, so zombies count as gone
+	assert.Eventually(t, func() bool {
+		stat, err := os.ReadFile(fmt.Sprintf("/proc/%d/stat", pid))
+		if err != nil {
+			return syscall.Kill(pid, 0) != nil
+		}
+		_, state, _ := strings.Cut(string(stat), ") ")
+		return strings.HasPrefix(state, "Z")
+	}, time.Second, 10*time.Millisecond)
+}
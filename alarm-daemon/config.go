@@ -0,0 +1,528 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+const CONFIG_POLL_INTERVAL = 5 * time.Second
+
+// config holds all daemon settings. It is read from the YAML file given in
+// CONFIG_FILE or, if that is not set, from the legacy environment variables.
+type config struct {
+	Source string `yaml:"source"`
+	// LingerTime is nil when it is not set. 0 is kept, alarms are then over
+	// when they arrive and never switch the display on.
+	LingerTime     *duration `yaml:"linger_time"`
+	CommandTimeout duration  `yaml:"command_timeout"`
+
+	// LastAlarmFile is only read to migrate its time into the history.
+	LastAlarmFile string `yaml:"last_alarm_file"`
+
+	PubSub struct {
+		ProjectID    string `yaml:"project_id"`
+		Subscription string `yaml:"subscription"`
+	} `yaml:"pubsub"`
+
+	Stream struct {
+		URL   string `yaml:"url"`
+		Token string `yaml:"token"`
+	} `yaml:"stream"`
+
+	Actions    actionsConfig    `yaml:"actions"`
+	Retry      retryConfig      `yaml:"retry"`
+	Reconcile  *reconcileConfig `yaml:"reconcile"`
+	Timestamps timestampsConfig `yaml:"timestamps"`
+	Rules      []ruleConfig     `yaml:"rules"`
+	Schedule   *scheduleConfig  `yaml:"schedule"`
+
+	// History is stored next to LastAlarmFile if no path is given, which
+	// migrates installations that only had the last alarm file.
+	History struct {
+		Path      string   `yaml:"path"`
+		Retention duration `yaml:"retention"`
+	} `yaml:"history"`
+
+	// Status enables the local status server. It only listens on localhost
+	// unless another address is given.
+	Status struct {
+		Enabled bool   `yaml:"enabled"`
+		Address string `yaml:"address"`
+	} `yaml:"status"`
+
+	// Override enables the override API on a Unix socket for the override
+	// command and, if the status server is enabled, on /api/override. The
+	// status server requires Token for overrides if it is set, and only
+	// accepts them on a loopback address without one.
+	Override struct {
+		Enabled bool   `yaml:"enabled"`
+		Socket  string `yaml:"socket"`
+		Token   string `yaml:"token"`
+	} `yaml:"override"`
+
+	// Shutdown is what happens on SIGINT and SIGTERM. Running actions may
+	// take Timeout to finish, SwitchOff runs the off actions.
+	Shutdown struct {
+		Timeout   duration `yaml:"timeout"`
+		SwitchOff bool     `yaml:"switch_off"`
+	} `yaml:"shutdown"`
+
+	// ClosedAlarms switches off Grace after Divera closed, archived or
+	// deleted an alarm, unless another one is still open.
+	ClosedAlarms struct {
+		SwitchOff bool     `yaml:"switch_off"`
+		Grace     duration `yaml:"grace"`
+	} `yaml:"closed_alarms"`
+}
+
+func (d *duration) UnmarshalYAML(value *yaml.Node) error {
+	v, err := time.ParseDuration(value.Value)
+	if err != nil {
+		return fmt.Errorf("line %d: %w", value.Line, err)
+	}
+	*d = duration(v)
+	return nil
+}
+
+func (c *config) applyDefaults() {
+	if c.Source == "" {
+		c.Source = "pubsub"
+	}
+	if c.PubSub.ProjectID == "" {
+		c.PubSub.ProjectID = os.Getenv("PROJECT_ID")
+	}
+	if c.LingerTime == nil {
+		d := duration(DEFAULT_LINGER_TIME)
+		c.LingerTime = &d
+	}
+	if c.CommandTimeout == 0 {
+		c.CommandTimeout = duration(DEFAULT_COMMAND_TIMEOUT)
+	}
+	if c.Status.Address == "" {
+		c.Status.Address = DEFAULT_STATUS_ADDRESS
+	}
+	if c.Override.Socket == "" {
+		c.Override.Socket = DEFAULT_OVERRIDE_SOCKET
+	}
+	if c.Shutdown.Timeout == 0 {
+		c.Shutdown.Timeout = duration(DEFAULT_SHUTDOWN_TIMEOUT)
+	}
+	if c.History.Path == "" && c.LastAlarmFile != "" {
+		c.History.Path = filepath.Join(filepath.Dir(c.LastAlarmFile), "history.db")
+	}
+	if c.History.Retention == 0 {
+		c.History.Retention = duration(DEFAULT_HISTORY_RETENTION)
+	}
+}
+
+// applySecrets lets secrets be kept out of the config file. STREAM_TOKEN
+// overrides the stream token, and every secret field may be written as
+// "env:NAME" or "file:/path" to read it from the environment or a file.
+func (c *config) applySecrets() error {
+	if token := os.Getenv("STREAM_TOKEN"); token != "" {
+		c.Stream.Token = token
+	}
+
+	var err error
+	if c.Stream.Token, err = resolveSecret(c.Stream.Token); err != nil {
+		return fmt.Errorf("stream.token: %w", err)
+	}
+	if c.Override.Token, err = resolveSecret(c.Override.Token); err != nil {
+		return fmt.Errorf("override.token: %w", err)
+	}
+	lists := []*actionListConfig{&c.Actions.On, &c.Actions.Off}
+	for i := range c.Rules {
+		if c.Rules[i].Actions != nil {
+			lists = append(lists, c.Rules[i].Actions)
+		}
+	}
+	if c.Schedule != nil && c.Schedule.RestrictActions != nil {
+		lists = append(lists, c.Schedule.RestrictActions)
+	}
+	if c.Retry.Alert != nil {
+		lists = append(lists, c.Retry.Alert)
+	}
+	var actions []*actionConfig
+	for _, list := range lists {
+		for i := range list.Actions {
+			actions = append(actions, &list.Actions[i])
+		}
+	}
+	if c.Retry.Verify != nil {
+		actions = append(actions, c.Retry.Verify)
+	}
+	for _, a := range actions {
+		if a.Password, err = resolveSecret(a.Password); err != nil {
+			return fmt.Errorf("action password: %w", err)
+		}
+		for k, v := range a.Headers {
+			if a.Headers[k], err = resolveSecret(v); err != nil {
+				return fmt.Errorf("action header %s: %w", k, err)
+			}
+		}
+	}
+	return nil
+}
+
+func resolveSecret(value string) (string, error) {
+	if name, ok := strings.CutPrefix(value, "env:"); ok {
+		v, ok := os.LookupEnv(name)
+		if !ok {
+			return "", fmt.Errorf("environment variable %s is not set", name)
+		}
+		return v, nil
+	}
+	if path, ok := strings.CutPrefix(value, "file:"); ok {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return "", err
+		}
+		return strings.TrimSpace(string(data)), nil
+	}
+	return value, nil
+}
+
+func (c *config) validate() error {
+	var errs []error
+
+	switch c.Source {
+	case "pubsub":
+		if c.PubSub.Subscription == "" {
+			errs = append(errs, errors.New("pubsub.subscription is required"))
+		}
+	case "stream":
+		if c.Stream.URL == "" {
+			errs = append(errs, errors.New("stream.url is required"))
+		}
+		if c.Stream.Token == "" {
+			errs = append(errs, errors.New("stream.token is required"))
+		}
+	default:
+		errs = append(errs, fmt.Errorf("source must be either pubsub or stream, not %s", c.Source))
+	}
+
+	if *c.LingerTime < 0 {
+		errs = append(errs, errors.New("linger_time must not be negative"))
+	}
+	if c.CommandTimeout <= 0 {
+		errs = append(errs, errors.New("command_timeout must be positive"))
+	}
+	if c.History.Retention < 0 {
+		errs = append(errs, errors.New("history.retention must not be negative"))
+	}
+	if c.Shutdown.Timeout < 0 {
+		errs = append(errs, errors.New("shutdown.timeout must not be negative"))
+	}
+	if c.ClosedAlarms.Grace < 0 {
+		errs = append(errs, errors.New("closed_alarms.grace must not be negative"))
+	}
+
+	if c.Status.Enabled {
+		if _, _, err := net.SplitHostPort(c.Status.Address); err != nil {
+			errs = append(errs, fmt.Errorf("status.address: %w", err))
+		}
+	}
+
+	if _, _, err := c.buildActions(); err != nil {
+		errs = append(errs, err)
+	}
+	if _, err := buildRules(c.Rules, time.Duration(c.CommandTimeout)); err != nil {
+		errs = append(errs, err)
+	}
+	if _, err := buildSchedule(c.Schedule, time.Duration(c.CommandTimeout)); err != nil {
+		errs = append(errs, err)
+	}
+	if _, err := buildRetryPolicy(c.Retry, time.Duration(c.CommandTimeout)); err != nil {
+		errs = append(errs, err)
+	}
+	if _, err := buildTimestampPolicy(c.Timestamps); err != nil {
+		errs = append(errs, err)
+	}
+	if _, err := buildReconciler(c.Reconcile, time.Duration(c.CommandTimeout)); err != nil {
+		errs = append(errs, err)
+	}
+
+	return errors.Join(errs...)
+}
+
+func (c *config) buildActions() (on, off *actionList, err error) {
+	on, err = buildActionList("on", c.Actions.On, time.Duration(c.CommandTimeout))
+	if err != nil {
+		return nil, nil, err
+	}
+	off, err = buildActionList("off", c.Actions.Off, time.Duration(c.CommandTimeout))
+	if err != nil {
+		return nil, nil, err
+	}
+	return on, off, nil
+}
+
+// watcherSettings returns the part of the config that can be changed while
+// the daemon is running.
+func (c *config) watcherSettings() (watcherSettings, error) {
+	on, off, err := c.buildActions()
+	if err != nil {
+		return watcherSettings{}, err
+	}
+	rules, err := buildRules(c.Rules, time.Duration(c.CommandTimeout))
+	if err != nil {
+		return watcherSettings{}, err
+	}
+	schedule, err := buildSchedule(c.Schedule, time.Duration(c.CommandTimeout))
+	if err != nil {
+		return watcherSettings{}, err
+	}
+	retry, err := buildRetryPolicy(c.Retry, time.Duration(c.CommandTimeout))
+	if err != nil {
+		return watcherSettings{}, err
+	}
+	reconcile, err := buildReconciler(c.Reconcile, time.Duration(c.CommandTimeout))
+	if err != nil {
+		return watcherSettings{}, err
+	}
+	timestamps, err := buildTimestampPolicy(c.Timestamps)
+	if err != nil {
+		return watcherSettings{}, err
+	}
+	return watcherSettings{
+		lingerTime: time.Duration(*c.LingerTime),
+		switchOn:   on.run,
+		switchOff:  off.run,
+		retry:      retry,
+		reconcile:  reconcile,
+		rules:      rules,
+		schedule:   schedule,
+		timestamps: timestamps,
+
+		offOnShutdown: c.Shutdown.SwitchOff,
+		offWhenClosed: c.ClosedAlarms.SwitchOff,
+		closedGrace:   time.Duration(c.ClosedAlarms.Grace),
+	}, nil
+}
+
+// requiresRestart reports the settings that differ from c but are only read
+// on startup.
+func (c *config) requiresRestart(other *config) []string {
+	var changed []string
+	if c.Source != other.Source {
+		changed = append(changed, "source")
+	}
+	if c.PubSub != other.PubSub {
+		changed = append(changed, "pubsub")
+	}
+	if c.Stream != other.Stream {
+		changed = append(changed, "stream")
+	}
+	if c.LastAlarmFile != other.LastAlarmFile {
+		changed = append(changed, "last_alarm_file")
+	}
+	if c.History != other.History {
+		changed = append(changed, "history")
+	}
+	if c.Status != other.Status {
+		changed = append(changed, "status")
+	}
+	if c.Override != other.Override {
+		changed = append(changed, "override")
+	}
+	if c.Shutdown.Timeout != other.Shutdown.Timeout {
+		changed = append(changed, "shutdown.timeout")
+	}
+	return changed
+}
+
+// loadConfig reads the config file if path is set, the environment otherwise.
+func loadConfig(path string) (*config, error) {
+	if path != "" {
+		return loadConfigFile(path)
+	}
+	return loadConfigFromEnv()
+}
+
+func loadConfigFile(path string) (*config, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	c := &config{}
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	decoder.KnownFields(true)
+	if err := decoder.Decode(c); err != nil {
+		return nil, fmt.Errorf("could not parse %s: %w", path, err)
+	}
+
+	c.applyDefaults()
+	if err := c.applySecrets(); err != nil {
+		return nil, err
+	}
+	if err := c.validate(); err != nil {
+		return nil, fmt.Errorf("invalid config %s: %w", path, err)
+	}
+	return c, nil
+}
+
+// loadConfigFromEnv reads the environment variables the daemon was
+// originally configured with.
+func loadConfigFromEnv() (*config, error) {
+	c := &config{
+		Source:        os.Getenv("ALARM_SOURCE"),
+		LastAlarmFile: os.Getenv("LAST_ALARM_FILE"),
+	}
+	c.PubSub.Subscription = os.Getenv("SUBSCRIPTION_NAME")
+	c.Stream.URL = os.Getenv("STREAM_URL")
+	c.Stream.Token = os.Getenv("STREAM_TOKEN")
+	c.Status.Enabled = os.Getenv("STATUS_ENABLED") == "true"
+	c.Status.Address = os.Getenv("STATUS_ADDRESS")
+	c.Override.Enabled = os.Getenv("OVERRIDE_ENABLED") == "true"
+	c.Override.Socket = os.Getenv("OVERRIDE_SOCKET")
+	c.Override.Token = os.Getenv("OVERRIDE_TOKEN")
+	c.History.Path = os.Getenv("HISTORY_FILE")
+	c.Shutdown.SwitchOff = os.Getenv("SHUTDOWN_SWITCH_OFF") == "true"
+	c.Timestamps.Future = os.Getenv("TIMESTAMP_FUTURE")
+	c.Timestamps.LingerFrom = os.Getenv("LINGER_FROM")
+	c.ClosedAlarms.SwitchOff = os.Getenv("CLOSED_ALARMS_SWITCH_OFF") == "true"
+
+	if val, ok := os.LookupEnv("LINGER_TIME"); ok {
+		v, err := time.ParseDuration(val)
+		if err != nil {
+			return nil, fmt.Errorf("LINGER_TIME environment variable is not a valid duration: %w", err)
+		}
+		d := duration(v)
+		c.LingerTime = &d
+	}
+	if val, ok := os.LookupEnv("COMMAND_TIMEOUT"); ok {
+		v, err := time.ParseDuration(val)
+		if err != nil {
+			return nil, fmt.Errorf("COMMAND_TIMEOUT environment variable is not a valid duration: %w", err)
+		}
+		c.CommandTimeout = duration(v)
+	}
+
+	if val, ok := os.LookupEnv("HISTORY_RETENTION"); ok {
+		v, err := time.ParseDuration(val)
+		if err != nil {
+			return nil, fmt.Errorf("HISTORY_RETENTION environment variable is not a valid duration: %w", err)
+		}
+		c.History.Retention = duration(v)
+	}
+	if val, ok := os.LookupEnv("TIMESTAMP_TOLERANCE"); ok {
+		v, err := time.ParseDuration(val)
+		if err != nil {
+			return nil, fmt.Errorf("TIMESTAMP_TOLERANCE environment variable is not a valid duration: %w", err)
+		}
+		c.Timestamps.Tolerance = duration(v)
+	}
+	if val, ok := os.LookupEnv("CLOSED_ALARMS_GRACE"); ok {
+		v, err := time.ParseDuration(val)
+		if err != nil {
+			return nil, fmt.Errorf("CLOSED_ALARMS_GRACE environment variable is not a valid duration: %w", err)
+		}
+		c.ClosedAlarms.Grace = duration(v)
+	}
+	if val, ok := os.LookupEnv("SHUTDOWN_TIMEOUT"); ok {
+		v, err := time.ParseDuration(val)
+		if err != nil {
+			return nil, fmt.Errorf("SHUTDOWN_TIMEOUT environment variable is not a valid duration: %w", err)
+		}
+		c.Shutdown.Timeout = duration(v)
+	}
+
+	if actionsFile := os.Getenv("ACTIONS_FILE"); actionsFile != "" {
+		actions, err := loadActionsConfig(actionsFile)
+		if err != nil {
+			return nil, fmt.Errorf("ACTIONS_FILE: %w", err)
+		}
+		c.Actions = *actions
+	} else {
+		switchOnCmd := os.Getenv("SWITCH_ON_CMD")
+		if switchOnCmd == "" {
+			return nil, errors.New("SWITCH_ON_CMD environment variable is not set")
+		}
+		switchOffCmd := os.Getenv("SWITCH_OFF_CMD")
+		if switchOffCmd == "" {
+			return nil, errors.New("SWITCH_OFF_CMD environment variable is not set")
+		}
+		c.Actions = actionsConfig{
+			On:  actionListConfig{Actions: []actionConfig{{Type: "command", Command: switchOnCmd}}},
+			Off: actionListConfig{Actions: []actionConfig{{Type: "command", Command: switchOffCmd}}},
+		}
+	}
+
+	c.applyDefaults()
+	if err := c.applySecrets(); err != nil {
+		return nil, err
+	}
+	if err := c.validate(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// watchConfig reloads the config file on SIGHUP and whenever its
+// modification time changes, and sends the new watcher settings to reload.
+// Invalid files are logged and ignored, the running settings stay in place.
+func watchConfig(ctx context.Context, path string, running *config, reload chan<- watcherSettings) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+
+	modTime := func() time.Time {
+		if info, err := os.Stat(path); err == nil {
+			return info.ModTime()
+		}
+		return time.Time{}
+	}
+	lastMod := modTime()
+
+	ticker := time.NewTicker(CONFIG_POLL_INTERVAL)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-hup:
+			log.Println("config: SIGHUP received, reloading")
+		case <-ticker.C:
+			if mod := modTime(); mod.Equal(lastMod) {
+				continue
+			} else {
+				lastMod = mod
+			}
+			log.Printf("config: %s changed, reloading\n", path)
+		}
+
+		next, err := loadConfigFile(path)
+		if err != nil {
+			log.Printf("config: reload failed, keeping current settings: %v\n", err)
+			continue
+		}
+		settings, err := next.watcherSettings()
+		if err != nil {
+			log.Printf("config: reload failed, keeping current settings: %v\n", err)
+			continue
+		}
+		if changed := running.requiresRestart(next); len(changed) > 0 {
+			log.Printf("config: changes to %s require a restart and are ignored\n", strings.Join(changed, ", "))
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case reload <- settings:
+		}
+	}
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func writeConfig(t *testing.T, content string) string {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	assert.NoError(t, os.WriteFile(path, []byte(content), 0600))
+	return path
+}
+
+func TestLoadConfigFile(t *testing.T) {
+	t.Setenv("TV_PLUG_TOKEN", "s3cr3t")
+
+	path := writeConfig(t, `
+source: stream
+stream:
+  url: https://alarm.example.org/stream
+  token: file:`+writeConfig(t, "t0k3n\n")+`
+linger_time: 20m
+actions:
+  on:
+    actions:
+      - type: command
+        command: /usr/local/bin/on.sh
+        timeout: 60s
+  off:
+    parallel: true
+    actions:
+      - type: command
+        command: /usr/local/bin/off.sh
+      - type: http
+        url: http://plug.local/relay/0?turn=off
+        headers:
+          Authorization: env:TV_PLUG_TOKEN
+`)
+
+	c, err := loadConfigFile(path)
+	assert.NoError(t, err)
+	assert.Equal(t, "stream", c.Source)
+	assert.Equal(t, "t0k3n", c.Stream.Token)
+	assert.Equal(t, duration(20*time.Minute), *c.LingerTime)
+	assert.Equal(t, duration(DEFAULT_COMMAND_TIMEOUT), c.CommandTimeout)
+	assert.Equal(t, duration(DEFAULT_SHUTDOWN_TIMEOUT), c.Shutdown.Timeout)
+	assert.Equal(t, duration(time.Minute), c.Actions.On.Actions[0].Timeout)
+	assert.True(t, c.Actions.Off.Parallel)
+	assert.Equal(t, "s3cr3t", c.Actions.Off.Actions[1].Headers["Authorization"])
+}
+
+func TestLoadConfigFileInvalid(t *testing.T) {
+	tt := []struct {
+		name    string
+		content string
+		err     string
+	}{
+		{
+			name:    "unknown field",
+			content: "lingertime: 5m\n",
+			err:     "field lingertime not found",
+		},
+		{
+			name:    "invalid duration",
+			content: "linger_time: soon\n",
+			err:     `invalid duration "soon"`,
+		},
+		{
+			name:    "missing subscription and actions",
+			content: "source: pubsub\n",
+			err:     "pubsub.subscription is required\nno on actions configured",
+		},
+		{
+			name: "unknown action",
+			content: `
+pubsub:
+  subscription: divera-alarm
+actions:
+  on:
+    actions: [{ type: telnet }]
+  off:
+    actions: [{ type: command, command: off.sh }]
+`,
+			err: `on action 1: unknown action type "telnet"`,
+		},
+		{
+			name: "missing secret",
+			content: `
+source: stream
+stream:
+  url: https://alarm.example.org/stream
+  token: env:DOES_NOT_EXIST
+`,
+			err: "environment variable DOES_NOT_EXIST is not set",
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := loadConfigFile(writeConfig(t, tc.content))
+			assert.ErrorContains(t, err, tc.err)
+		})
+	}
+}
+
+func TestLingerTimeZero(t *testing.T) {
+	const content = `
+pubsub:
+  subscription: divera-alarm
+actions:
+  on:
+    actions: [{ type: command, command: on.sh }]
+  off:
+    actions: [{ type: command, command: off.sh }]
+`
+	c, err := loadConfigFile(writeConfig(t, content))
+	assert.NoError(t, err)
+	assert.Equal(t, duration(DEFAULT_LINGER_TIME), *c.LingerTime)
+
+	// 0 is not replaced by the default
+	c, err = loadConfigFile(writeConfig(t, "linger_time: 0s\n"+content))
+	assert.NoError(t, err)
+	assert.Equal(t, duration(0), *c.LingerTime)
+
+	_, err = loadConfigFile(writeConfig(t, "linger_time: -1m\n"+content))
+	assert.ErrorContains(t, err, "linger_time must not be negative")
+
+	t.Setenv("SUBSCRIPTION_NAME", "divera-alarm")
+	t.Setenv("SWITCH_ON_CMD", "on.sh")
+	t.Setenv("SWITCH_OFF_CMD", "off.sh")
+	t.Setenv("LINGER_TIME", "0")
+	c, err = loadConfigFromEnv()
+	assert.NoError(t, err)
+	assert.Equal(t, duration(0), *c.LingerTime)
+}
+
+func TestWatcherReload(t *testing.T) {
+	w := newTestWatcher()
+	w.timer = alarmTimer{lastUpdate: time.Now().Add(-5 * time.Minute)}
+	settings := watcherSettings{lingerTime: time.Hour, switchOn: w.action("on", nil), switchOff: w.action("off", nil)}
+	w.start(t, settings)
+	assert.Equal(t, "on", <-w.switched)
+
+	// with the shorter linger time the alarm is already over
+	settings.lingerTime = time.Minute
+	w.reload <- settings
+	assert.Equal(t, "off", <-w.switched)
+}
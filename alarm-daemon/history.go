@@ -0,0 +1,258 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"time"
+
+	messages "github.com/CaptainStandby/divera-monitor/proto"
+	bolt "go.etcd.io/bbolt"
+	"google.golang.org/protobuf/encoding/protojson"
+)
+
+const DEFAULT_HISTORY_RETENTION = 90 * 24 * time.Hour
+
+var (
+	alarmsBucket   = []byte("alarms")
+	switchesBucket = []byte("switches")
+	metaBucket     = []byte("meta")
+
+	lastUpdateKey = []byte("last_update")
+	lingerTimeKey = []byte("linger_time")
+)
+
+// historyStore keeps every received alarm and every switch of the display in
+// a bbolt database. Records older than the retention are removed when new
+// ones are written. All methods do nothing on a nil store, so the watcher
+// works without a history.
+type historyStore struct {
+	db        *bolt.DB
+	retention time.Duration
+}
+
+type alarmRecord struct {
+	Received time.Time       `json:"received"`
+	Alarm    json.RawMessage `json:"alarm"`
+}
+
+// switchRecord is written for the on and off actions, and for verify and
+// alert of the retry policy.
+type switchRecord struct {
+	Time      time.Time       `json:"time"`
+	Direction string          `json:"direction"`
+	Duration  string          `json:"duration"`
+	Error     string          `json:"error,omitempty"`
+	Commands  []commandResult `json:"commands,omitempty"`
+}
+
+func openHistory(path string, retention time.Duration) (*historyStore, error) {
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: 5 * time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("could not open history %s: %w", path, err)
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		for _, name := range [][]byte{alarmsBucket, switchesBucket, metaBucket} {
+			if _, err := tx.CreateBucketIfNotExists(name); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("could not initialize history %s: %w", path, err)
+	}
+	return &historyStore{db: db, retention: retention}, nil
+}
+
+func (h *historyStore) Close() error {
+	return h.db.Close()
+}
+
+// recordKey sorts by time. The sequence keeps records of the same instant
+// apart.
+func recordKey(b *bolt.Bucket, t time.Time) []byte {
+	seq, _ := b.NextSequence()
+	key := make([]byte, 16)
+	binary.BigEndian.PutUint64(key, uint64(t.UnixNano()))
+	binary.BigEndian.PutUint64(key[8:], seq)
+	return key
+}
+
+func (h *historyStore) append(bucket []byte, t time.Time, record any) {
+	if h == nil {
+		return
+	}
+	data, err := json.Marshal(record)
+	if err != nil {
+		log.Printf("history: json.Marshal err: %v\n", err)
+		return
+	}
+
+	err = h.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(bucket)
+		if err := b.Put(recordKey(b, t), data); err != nil {
+			return err
+		}
+		return h.prune(b, t)
+	})
+	if err != nil {
+		log.Printf("history: could not write to %s: %v\n", bucket, err)
+	}
+}
+
+// prune removes the records that are older than the retention.
+func (h *historyStore) prune(b *bolt.Bucket, now time.Time) error {
+	if h.retention <= 0 {
+		return nil
+	}
+	cutoff := make([]byte, 8)
+	binary.BigEndian.PutUint64(cutoff, uint64(now.Add(-h.retention).UnixNano()))
+
+	c := b.Cursor()
+	for k, _ := c.First(); k != nil && bytes.Compare(k[:8], cutoff) < 0; k, _ = c.First() {
+		if err := c.Delete(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (h *historyStore) recordAlarm(msg *messages.Alarm, received time.Time) {
+	if h == nil {
+		return
+	}
+	data, err := protojson.Marshal(msg)
+	if err != nil {
+		log.Printf("history: protojson.Marshal err: %v\n", err)
+		return
+	}
+	h.append(alarmsBucket, received, alarmRecord{Received: received, Alarm: data})
+}
+
+func (h *historyStore) recordSwitch(direction string, started time.Time, err error, commands []commandResult) {
+	record := switchRecord{
+		Time:      started,
+		Direction: direction,
+		Duration:  time.Since(started).Round(time.Millisecond).String(),
+		Commands:  commands,
+	}
+	if err != nil {
+		record.Error = err.Error()
+	}
+	h.append(switchesBucket, started, record)
+}
+
+// storeLastUpdate replaces LAST_ALARM_FILE.
+func (h *historyStore) storeLastUpdate(t time.Time) {
+	if h == nil {
+		return
+	}
+	err := h.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(metaBucket).Put(lastUpdateKey, []byte(t.UTC().Format(time.RFC3339)))
+	})
+	if err != nil {
+		log.Printf("history: could not store last update: %v\n", err)
+	}
+}
+
+// storeTimer is used as storeTime of the alarmTimer. The linger time is
+// stored with the last update, so the standby time survives a restart.
+func (h *historyStore) storeTimer(lastUpdate time.Time, lingerTime time.Duration) {
+	if h == nil {
+		return
+	}
+	err := h.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(metaBucket)
+		if err := b.Put(lastUpdateKey, []byte(lastUpdate.UTC().Format(time.RFC3339))); err != nil {
+			return err
+		}
+		return b.Put(lingerTimeKey, []byte(lingerTime.String()))
+	})
+	if err != nil {
+		log.Printf("history: could not store the timer: %v\n", err)
+	}
+}
+
+// lastUpdate returns the zero time if none was stored yet.
+func (h *historyStore) lastUpdate() (time.Time, error) {
+	var t time.Time
+	err := h.db.View(func(tx *bolt.Tx) error {
+		v := tx.Bucket(metaBucket).Get(lastUpdateKey)
+		if v == nil {
+			return nil
+		}
+		var err error
+		t, err = time.Parse(time.RFC3339, string(v))
+		return err
+	})
+	return t, err
+}
+
+// lingerTime returns 0 if none was stored yet.
+func (h *historyStore) lingerTime() (time.Duration, error) {
+	var d time.Duration
+	err := h.db.View(func(tx *bolt.Tx) error {
+		v := tx.Bucket(metaBucket).Get(lingerTimeKey)
+		if v == nil {
+			return nil
+		}
+		var err error
+		d, err = time.ParseDuration(string(v))
+		return err
+	})
+	return d, err
+}
+
+// lastAlarm returns the most recently received alarm, or nil.
+func (h *historyStore) lastAlarm() (*messages.Alarm, time.Time, error) {
+	var record alarmRecord
+	err := h.db.View(func(tx *bolt.Tx) error {
+		_, v := tx.Bucket(alarmsBucket).Cursor().Last()
+		if v == nil {
+			return nil
+		}
+		return json.Unmarshal(v, &record)
+	})
+	if err != nil || record.Alarm == nil {
+		return nil, time.Time{}, err
+	}
+
+	msg := &messages.Alarm{}
+	if err := protojson.Unmarshal(record.Alarm, msg); err != nil {
+		return nil, time.Time{}, err
+	}
+	return msg, record.Received, nil
+}
+
+// migrateLastAlarmFile takes the time from LAST_ALARM_FILE into a history
+// that does not have one yet. The file itself is left alone.
+func (h *historyStore) migrateLastAlarmFile(lastAlarmFile string) error {
+	if lastAlarmFile == "" {
+		return nil
+	}
+	if _, err := os.Stat(lastAlarmFile); errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	stored, err := h.lastUpdate()
+	if err != nil {
+		return err
+	}
+	if !stored.IsZero() {
+		return nil
+	}
+
+	t := loadLastAlarmTime(lastAlarmFile)
+	if t.Equal(time.Unix(0, 0)) {
+		return fmt.Errorf("no time found in %s", lastAlarmFile)
+	}
+	h.storeLastUpdate(t)
+	log.Printf("history: migrated last alarm time %s from %s\n", t, lastAlarmFile)
+	return nil
+}
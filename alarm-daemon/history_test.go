@@ -0,0 +1,141 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	messages "github.com/CaptainStandby/divera-monitor/proto"
+	"github.com/stretchr/testify/assert"
+	bolt "go.etcd.io/bbolt"
+)
+
+func TestHistoryStore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "history.db")
+	history, err := openHistory(path, time.Hour)
+	assert.NoError(t, err)
+
+	msg, _, err := history.lastAlarm()
+	assert.NoError(t, err)
+	assert.Nil(t, msg)
+
+	now := time.Now()
+	history.recordAlarm(&messages.Alarm{Id: 1, Title: "old"}, now.Add(-2*time.Hour))
+	history.recordAlarm(&messages.Alarm{Id: 2, Title: "B3 Wohnungsbrand"}, now)
+	history.recordSwitch("on", now, nil, nil)
+	history.recordSwitch("off", now, errors.New("exit status 1"), nil)
+	history.storeLastUpdate(now)
+	assert.NoError(t, history.Close())
+
+	// everything survives a restart
+	history, err = openHistory(path, time.Hour)
+	assert.NoError(t, err)
+	defer history.Close()
+
+	lastUpdate, err := history.lastUpdate()
+	assert.NoError(t, err)
+	assert.Equal(t, now.Truncate(time.Second).UTC(), lastUpdate.UTC())
+
+	msg, received, err := history.lastAlarm()
+	assert.NoError(t, err)
+	assert.Equal(t, int64(2), msg.Id)
+	assert.Equal(t, "B3 Wohnungsbrand", msg.Title)
+	assert.True(t, now.Equal(received))
+
+	// the alarm older than the retention was removed
+	var alarms, switches int
+	assert.NoError(t, history.db.View(func(tx *bolt.Tx) error {
+		alarms = tx.Bucket(alarmsBucket).Stats().KeyN
+		switches = tx.Bucket(switchesBucket).Stats().KeyN
+		return nil
+	}))
+	assert.Equal(t, 1, alarms)
+	assert.Equal(t, 2, switches)
+}
+
+func TestHistoryMigration(t *testing.T) {
+	dir := t.TempDir()
+	lastAlarmFile := filepath.Join(dir, "lastAlarm")
+	assert.NoError(t, os.WriteFile(lastAlarmFile, []byte("2023-07-19T09:00:11Z\n"), 0644))
+
+	history, err := openHistory(filepath.Join(dir, "history.db"), time.Hour)
+	assert.NoError(t, err)
+	defer history.Close()
+
+	assert.NoError(t, history.migrateLastAlarmFile(lastAlarmFile))
+	lastUpdate, err := history.lastUpdate()
+	assert.NoError(t, err)
+	assert.Equal(t, time.Date(2023, 7, 19, 9, 0, 11, 0, time.UTC), lastUpdate.UTC())
+
+	// a newer time in the history is not overwritten
+	history.storeLastUpdate(time.Date(2023, 7, 20, 0, 0, 0, 0, time.UTC))
+	assert.NoError(t, history.migrateLastAlarmFile(lastAlarmFile))
+	lastUpdate, err = history.lastUpdate()
+	assert.NoError(t, err)
+	assert.Equal(t, time.Date(2023, 7, 20, 0, 0, 0, 0, time.UTC), lastUpdate.UTC())
+
+	// nothing to migrate on new installations
+	assert.NoError(t, history.migrateLastAlarmFile(filepath.Join(dir, "missing")))
+}
+
+func TestHistoryDefaultPath(t *testing.T) {
+	c := &config{LastAlarmFile: "/home/alarmdaemon/.alarm-daemon/work/lastAlarm"}
+	c.applyDefaults()
+	assert.Equal(t, "/home/alarmdaemon/.alarm-daemon/work/history.db", c.History.Path)
+	assert.Equal(t, duration(DEFAULT_HISTORY_RETENTION), c.History.Retention)
+}
+
+func TestWatcherTimerRestart(t *testing.T) {
+	cfg := &config{}
+	cfg.History.Path = filepath.Join(t.TempDir(), "history.db")
+
+	settings := watcherSettings{
+		lingerTime: 15 * time.Minute,
+		rules: &ruleSet{rules: []rule{
+			{name: "info", match: matchConfig{NotificationType: []int32{4}}, lingerTime: time.Hour},
+		}},
+	}
+	start := func() *testWatcher {
+		w := newTestWatcher()
+		w.history, w.timer = restoreHistory(cfg, w.status)
+		settings.switchOn, settings.switchOff = w.action("on", nil), w.action("off", nil)
+		return w.start(t, settings)
+	}
+	stop := func(w *testWatcher) {
+		w.stop()
+		assert.NoError(t, w.history.Close())
+	}
+
+	now := time.Now()
+	w := start()
+	w.alarm(&messages.Alarm{Id: 1, NotificationType: 4}, now)
+	assert.Equal(t, "on", <-w.switched)
+	assert.NoError(t, w.override(overrideExtend, 10).err)
+	stop(w)
+
+	// the linger time of the rule and the extension survive the restart and
+	// a reload
+	standby := now.Truncate(time.Second).Add(70 * time.Minute)
+	w = start()
+	assert.Equal(t, "on", <-w.switched)
+	w.reload <- settings
+	timer := w.status.timer(time.Now())
+	assert.Equal(t, "1h10m0s", timer.LingerTime)
+	assert.True(t, standby.Equal(timer.StandbyTime), "standby at %s", timer.StandbyTime)
+
+	assert.NoError(t, w.override(overrideOff, 0).err)
+	assert.Equal(t, "off", <-w.switched)
+	stop(w)
+
+	// the alarm that was switched off stays off
+	w = start()
+	defer stop(w)
+	assert.ErrorIs(t, w.override(overrideExtend, 10).err, errNoActiveAlarm)
+	select {
+	case s := <-w.switched:
+		t.Fatalf("switched %s after the restart", s)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
@@ -0,0 +1,180 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+)
+
+const KIOSK_HEARTBEAT_INTERVAL = 15 * time.Second
+
+// serveEvents streams a status snapshot as Server-Sent Event whenever the
+// alarm or the timer changes, and once right after connecting.
+func serveEvents(status *daemonStatus) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		flusher, ok := w.(http.Flusher)
+		if !ok {
+			http.Error(w, "streaming not supported", http.StatusInternalServerError)
+			return
+		}
+
+		updates, unsubscribe := status.subscribe()
+		defer unsubscribe()
+
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Header().Set("Cache-Control", "no-store")
+		w.Header().Set("Connection", "keep-alive")
+
+		send := func() error {
+			data, err := json.Marshal(status.snapshot(time.Now()))
+			if err != nil {
+				return err
+			}
+			if _, err := fmt.Fprintf(w, "event: status\ndata: %s\n\n", data); err != nil {
+				return err
+			}
+			flusher.Flush()
+			return nil
+		}
+
+		if err := send(); err != nil {
+			log.Printf("kiosk: %v\n", err)
+			return
+		}
+
+		heartbeat := time.NewTicker(KIOSK_HEARTBEAT_INTERVAL)
+		defer heartbeat.Stop()
+
+		for {
+			select {
+			case <-r.Context().Done():
+				return
+			case <-updates:
+				if err := send(); err != nil {
+					return
+				}
+			case <-heartbeat.C:
+				if _, err := fmt.Fprint(w, ": heartbeat\n\n"); err != nil {
+					return
+				}
+				flusher.Flush()
+			}
+		}
+	}
+}
+
+func serveKiosk(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	fmt.Fprint(w, kioskPage)
+}
+
+// kioskPage is shown full-screen on the display. It keeps the last snapshot
+// and redraws every second, so elapsed time and countdown keep running and it
+// falls back to the idle screen on its own when the standby time has passed.
+const kioskPage = `<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>Alarm</title>
+<style>
+html, body { margin: 0; height: 100%; background: #111; color: #eee; font-family: sans-serif; overflow: hidden; }
+#idle, #alarm { display: none; height: 100%; flex-direction: column; }
+#idle { align-items: center; justify-content: center; }
+#clock { font-size: 20vh; font-weight: bold; }
+#date { font-size: 5vh; color: #999; }
+#banner { padding: 2vh 3vw; font-size: 8vh; font-weight: bold; text-transform: uppercase; background: #e65c00; color: #fff; }
+#banner.priority { background: #c00; animation: blink 1s steps(1) infinite; }
+@keyframes blink { 50% { background: #800; } }
+#content { flex: 1; padding: 3vh 3vw; }
+#title { font-size: 9vh; font-weight: bold; margin-bottom: 2vh; }
+#text { font-size: 5vh; white-space: pre-wrap; margin-bottom: 3vh; }
+#address { font-size: 6vh; }
+#position { font-size: 3vh; color: #999; }
+#footer { display: flex; justify-content: space-between; padding: 2vh 3vw; font-size: 5vh; background: #222; }
+#offline { position: fixed; bottom: 0; right: 0; padding: 1vh 1vw; background: #c00; color: #fff; display: none; }
+</style>
+</head>
+<body>
+<div id="idle">
+  <div id="clock"></div>
+  <div id="date"></div>
+</div>
+<div id="alarm">
+  <div id="banner"></div>
+  <div id="content">
+    <div id="title"></div>
+    <div id="text"></div>
+    <div id="address"></div>
+    <div id="position"></div>
+  </div>
+  <div id="footer">
+    <div>Since alarm: <span id="elapsed"></span></div>
+    <div>Standby in: <span id="countdown"></span></div>
+  </div>
+</div>
+<div id="offline">connection lost</div>
+<script>
+var state = null;
+var offset = 0; // server time minus local time
+
+function $(id) { return document.getElementById(id); }
+
+function pad(n) { return (n < 10 ? "0" : "") + n; }
+
+function formatDuration(ms) {
+  var s = Math.max(0, Math.floor(ms / 1000));
+  var h = Math.floor(s / 3600), m = Math.floor(s / 60) % 60;
+  return (h > 0 ? h + ":" + pad(m) : m) + ":" + pad(s % 60);
+}
+
+function show(id) {
+  $("idle").style.display = id === "idle" ? "flex" : "none";
+  $("alarm").style.display = id === "alarm" ? "flex" : "none";
+}
+
+function render() {
+  var now = Date.now() + offset;
+  var standby = state ? Date.parse(state.timer.standby_time) : 0;
+
+  if (!state || now >= standby) {
+    var d = new Date(now);
+    $("clock").textContent = pad(d.getHours()) + ":" + pad(d.getMinutes());
+    $("date").textContent = d.toLocaleDateString(undefined, { weekday: "long", year: "numeric", month: "long", day: "numeric" });
+    show("idle");
+    return;
+  }
+
+  var alarm = state.last_alarm ? state.last_alarm.alarm : {};
+  $("banner").textContent = alarm.priority ? "Priority alarm" : "Alarm";
+  $("banner").className = alarm.priority ? "priority" : "";
+  $("title").textContent = alarm.title || "";
+  $("text").textContent = alarm.text || "";
+  $("address").textContent = alarm.address || "";
+  $("position").textContent = alarm.position ?
+    (alarm.position.latitude || 0).toFixed(5) + ", " + (alarm.position.longitude || 0).toFixed(5) : "";
+
+  var created = alarm.created ? Number(alarm.created.seconds) * 1000 : Date.parse(state.timer.last_update);
+  $("elapsed").textContent = formatDuration(now - created);
+  $("countdown").textContent = formatDuration(standby - now);
+  show("alarm");
+}
+
+var events = new EventSource("/api/events");
+events.addEventListener("status", function (e) {
+  state = JSON.parse(e.data);
+  offset = Date.parse(state.now) - Date.now();
+  $("offline").style.display = "none";
+  render();
+});
+events.onerror = function () { $("offline").style.display = "block"; };
+
+render();
+setInterval(render, 1000);
+</script>
+</body>
+</html>
+`
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	messages "github.com/CaptainStandby/divera-monitor/proto"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestKioskEvents(t *testing.T) {
+	status := newDaemonStatus("pubsub")
+	server := httptest.NewServer(newStatusHandler(status, nil, ""))
+	defer server.Close()
+
+	res, err := http.Get(server.URL + "/api/events")
+	assert.NoError(t, err)
+	defer res.Body.Close()
+	assert.Equal(t, "text/event-stream", res.Header.Get("Content-Type"))
+
+	scanner := bufio.NewScanner(res.Body)
+	next := func() statusSnapshot {
+		var snap statusSnapshot
+		for scanner.Scan() {
+			if data, ok := strings.CutPrefix(scanner.Text(), "data: "); ok {
+				assert.NoError(t, json.Unmarshal([]byte(data), &snap))
+				return snap
+			}
+		}
+		t.Fatal("stream ended")
+		return snap
+	}
+
+	snap := next()
+	assert.False(t, snap.Timer.Active)
+	assert.Nil(t, snap.LastAlarm)
+
+	status.alarmReceived(&messages.Alarm{Id: 42, Title: "B3 Wohnungsbrand", Priority: true}, time.Now())
+	status.setTimer(&alarmTimer{lastUpdate: time.Now(), lingerTime: 15 * time.Minute})
+
+	// updates are coalesced, wait for the one that has both
+	for snap = next(); !snap.Timer.Active; snap = next() {
+	}
+	assert.JSONEq(t, `{"id":"42","title":"B3 Wohnungsbrand","priority":true}`, string(snap.LastAlarm.Alarm))
+}
+
+func TestKioskPage(t *testing.T) {
+	rec := httptest.NewRecorder()
+	newStatusHandler(newDaemonStatus("pubsub"), nil, "").ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/kiosk", nil))
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Contains(t, rec.Body.String(), `new EventSource("/api/events")`)
+}
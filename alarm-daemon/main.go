@@ -4,15 +4,16 @@ import (
 	"bufio"
 	"context"
 	"fmt"
+	"io"
 	"log"
+	"net/http"
 	"os"
-	"os/exec"
-	"os/signal"
-	"syscall"
+	"sync"
 	"time"
 
 	"cloud.google.com/go/pubsub"
 	messages "github.com/CaptainStandby/divera-monitor/proto"
+	"github.com/prometheus/client_golang/prometheus"
 	"golang.org/x/oauth2/google"
 	"google.golang.org/api/option"
 	"google.golang.org/protobuf/encoding/protojson"
@@ -23,23 +24,44 @@ func toTime(t *messages.Alarm_Timestamp) time.Time {
 	return time.Unix(t.Seconds, 0)
 }
 
-type trigger func(context.Context) error
+// trigger switches the display for an alarm, which is nil if there is none.
+type trigger func(context.Context, *messages.Alarm) error
 
 const DEFAULT_LINGER_TIME = 15 * time.Minute
+
+// HANDLED_RETENTION is how long the watcher remembers the versions of the
+// alarms it handled.
+const HANDLED_RETENTION = 24 * time.Hour
 const DEFAULT_COMMAND_TIMEOUT = 30 * time.Second
 
 type alarmTimer struct {
 	lingerTime time.Duration
 	lastUpdate time.Time
-	storeTime  func(time.Time)
+	storeTime  func(lastUpdate time.Time, lingerTime time.Duration)
 }
 
-func (a *alarmTimer) update(t time.Time) {
-	if t.After(a.lastUpdate) {
+// extend moves the standby time to t plus lingerTime, if that is later than
+// the current one. Alarms with a shorter linger time never cut off a running
+// one.
+func (a *alarmTimer) extend(t time.Time, lingerTime time.Duration) {
+	if t.Add(lingerTime).After(a.standbyTime()) {
 		a.lastUpdate = t
-		if a.storeTime != nil {
-			a.storeTime(t)
-		}
+		a.lingerTime = lingerTime
+		a.save()
+	}
+}
+
+// cut ends the alarm at t. The linger time is kept, so an alarm extended
+// or lingering by a rule is cut at t as well after a restart.
+func (a *alarmTimer) cut(t time.Time) {
+	a.lastUpdate = t.Add(-a.lingerTime)
+	a.save()
+}
+
+// save stores the timer, it is called whenever the standby time changes.
+func (a *alarmTimer) save() {
+	if a.storeTime != nil {
+		a.storeTime(a.lastUpdate, a.lingerTime)
 	}
 }
 
@@ -62,47 +84,596 @@ func (a *alarmTimer) standby() <-chan time.Time {
 	return time.After(time.Until(a.standbyTime()))
 }
 
+// watcherSettings can be changed while the watcher is running.
+type watcherSettings struct {
+	lingerTime          time.Duration
+	switchOn, switchOff trigger
+	retry               retryPolicy
+	reconcile           *reconciler
+	rules               *ruleSet
+	schedule            *schedule
+	timestamps          timestampPolicy
+	// offWhenClosed ends the alarm closedGrace after Divera closed, archived
+	// or deleted the last open one.
+	offWhenClosed bool
+	closedGrace   time.Duration
+	// offOnShutdown runs the off actions when the daemon is stopped.
+	offOnShutdown bool
+}
+
+// ruleDecision decides how to react to msg, using the defaults if no rule
+// matches.
+func (s *watcherSettings) ruleDecision(msg *messages.Alarm) decision {
+	return s.rules.evaluate(msg, decision{lingerTime: s.lingerTime, switchOn: s.switchOn, actions: "default"})
+}
+
+// evaluate is the rule decision restricted by the schedule at now.
+func (s *watcherSettings) evaluate(msg *messages.Alarm, now time.Time) decision {
+	return s.schedule.apply(s.ruleDecision(msg), msg, now)
+}
+
+// watcher switches the display for the alarms from pipeline until ctx is
+// done or pipeline is closed. After pipeline is closed, it finishes by
+// running the off actions if offOnShutdown is set and returns their error.
 func watcher(
 	ctx context.Context,
-	pipeline <-chan *messages.Alarm,
-	switchOn, switchOff trigger,
-	timer alarmTimer) {
-
-	activate := func() {
-		if timer.isActive() {
-			log.Printf("watcher: alarm is active, switching on\n")
-			if err := switchOn(ctx); err != nil {
-				log.Printf("watcher: switchOn err: %v\n", err)
+	pipeline <-chan delivery,
+	reload <-chan watcherSettings,
+	overrides <-chan overrideRequest,
+	settings watcherSettings,
+	timer alarmTimer,
+	status *daemonStatus,
+	history *historyStore) error {
+
+	// a timer restored from the history keeps its linger time, also on
+	// reloads. Histories that do not have one yet get the configured one.
+	restored := timer.lingerTime > 0
+	if !restored {
+		timer.lingerTime = settings.lingerTime
+	}
+	status.setTimer(&timer)
+
+	// current is passed to the actions. It is the alarm restored from the
+	// history until one is received and stays set when the alarm is over, so
+	// the off actions get it too.
+	current := status.alarm()
+
+	// lastOn is the trigger the display was last switched on with, the
+	// reconciliation uses it again if it finds the display off. mismatchSince
+	// is when it first found the display in another state than desired.
+	var lastOn trigger
+	var mismatchSince time.Time
+
+	// lastOff are the off actions of the rule the display was last switched
+	// on for, nil for the default ones.
+	var lastOff trigger
+
+	// busy is held by actions and power checks, cec-client can only be
+	// run once at a time. An action cancels a running check with
+	// cancelCheck instead of waiting for it. actions counts the actions that
+	// were run, a power check that was started before the last one is
+	// outdated.
+	var busy sync.Mutex
+	var cancelCheck context.CancelFunc
+	var actions int
+
+	// run reports the outcome of t and the commands it ran to the status
+	// and the history.
+	run := func(name string, t trigger) error {
+		ctx, recorder := withCommandRecorder(ctx)
+		if cancelCheck != nil {
+			cancelCheck()
+		}
+		busy.Lock()
+		actions++
+		start := time.Now()
+		err := t(ctx, current)
+		busy.Unlock()
+		status.actionFinished(name, start, err, recorder.list())
+		history.recordSwitch(name, start, err, recorder.list())
+		return err
+	}
+
+	runOn := func(switchOn trigger) error {
+		lastOn = switchOn
+		mismatchSince = time.Time{}
+		switchAttempts.WithLabelValues("on").Inc()
+		err := run("on", switchOn)
+		if err != nil {
+			log.Printf("watcher: switchOn err: %v\n", err)
+			switchFailures.WithLabelValues("on").Inc()
+		}
+		return err
+	}
+
+	// attempt counts the tries to switch on since the last activation,
+	// retryAt fires when the next one is due.
+	var attempt int
+	var retryOn trigger
+	var retryAt <-chan time.Time
+
+	// tryOn switches on and verifies the result. Failures are retried until
+	// the attempts of the retry policy are used up, then the alert runs.
+	tryOn := func(switchOn trigger) error {
+		retryAt = nil
+		err := runOn(switchOn)
+		if verify := settings.retry.verify; verify != nil {
+			verr := run("verify", verify)
+			switch {
+			case verr == nil && err != nil:
+				log.Printf("watcher: switched on despite the error, verify succeeded\n")
+				err = nil
+			case verr != nil && err == nil:
+				err = fmt.Errorf("verify: %w", verr)
+				log.Printf("watcher: %v\n", err)
 			}
 		}
+		if err == nil {
+			return nil
+		}
+
+		if attempt < settings.retry.attempts {
+			delay := settings.retry.delay(attempt)
+			log.Printf("watcher: switching on failed, retrying in %s (attempt %d of %d)\n", delay, attempt+1, settings.retry.attempts)
+			attempt++
+			retryOn = switchOn
+			retryAt = time.After(delay)
+			return err
+		}
+
+		log.Printf("watcher: switching on failed after %d attempts\n", attempt)
+		switchGiveUps.Inc()
+		if alert := settings.retry.alert; alert != nil {
+			if aerr := run("alert", alert); aerr != nil {
+				log.Printf("watcher: alert err: %v\n", aerr)
+			}
+		}
+		return err
+	}
+
+	// activate reports whether the display was switched on successfully.
+	activate := func(switchOn trigger) bool {
+		if timer.isExpired() {
+			return false
+		}
+		log.Printf("watcher: alarm is active, switching on\n")
+		attempt = 1
+		return tryOn(switchOn) == nil
+	}
+
+	deactivate := func() error {
+		mismatchSince = time.Time{}
+		switchOff := settings.switchOff
+		if lastOff != nil {
+			switchOff = lastOff
+		}
+		switchAttempts.WithLabelValues("off").Inc()
+		err := run("off", switchOff)
+		if err != nil {
+			log.Printf("watcher: switchOff err: %v\n", err)
+			switchFailures.WithLabelValues("off").Inc()
+		}
+		return err
+	}
+
+	// the latency is measured once per alarm, updates would skew it
+	var measuredID int64
+
+	// timerAlarm is the alarm that set the standby time, its linger time is
+	// evaluated again when the rules change.
+	var timerAlarm *messages.Alarm
+
+	// delayed is the switch on held back by the schedule until it opens. The
+	// timer keeps running meanwhile, so an alarm that is over by then is not
+	// shown at all. opened fires when the schedule opens, it is only set
+	// again when the schedule may have been changed by a reload.
+	var delayed trigger
+	var opened <-chan time.Time
+
+	// extension is the time added by extend overrides, it is kept when the
+	// linger time is evaluated again. manual is set while the timer was set
+	// by a force on, whose linger time is not changed by reloads.
+	var extension time.Duration
+	var manual bool
+
+	// open are the alarms that extended the timer since it was last over and
+	// were not closed, with the standby time each of them would have alone.
+	// A snooze ignores their updates until an alarm with another ID arrives.
+	open := map[int64]time.Time{}
+	if current != nil && timer.isActive() {
+		open[current.Id] = timer.standbyTime()
+	}
+	var snoozed map[int64]bool
+
+	// handled is the last update of every alarm handled within
+	// HANDLED_RETENTION. Pub/Sub delivers at least once, and the webhook and
+	// the poller both publish an update, so versions that were handled
+	// already are dropped. Deletions have no version.
+	handled := map[int64]time.Time{}
+	if current != nil && current.Updated != nil {
+		handled[current.Id] = toTime(current.Updated)
 	}
 
-	activate()
+	// closing fires the grace time after the last open alarm was closed.
+	var closing <-chan time.Time
+
+	// reset forgets the alarm once the timer is over.
+	reset := func() {
+		timerAlarm = nil
+		delayed = nil
+		opened = nil
+		extension = 0
+		manual = false
+		open = map[int64]time.Time{}
+		closing = nil
+		retryAt = nil
+	}
+
+	// end switches off before the timer is over.
+	end := func(now time.Time) {
+		timer.cut(now)
+		reset()
+		status.setTimer(&timer)
+		deactivate()
+	}
+
+	// closed stops tracking an alarm that Divera closed, archived or deleted.
+	// Without any other open alarm the display is switched off, unless it was
+	// switched on by hand. Otherwise the standby time is the latest of the
+	// remaining alarms.
+	closed := func(msg *messages.Alarm, now time.Time) {
+		if _, ok := open[msg.Id]; !ok {
+			log.Printf("watcher: alarm %d is finished, it was not open\n", msg.Id)
+			return
+		}
+		delete(open, msg.Id)
+		if !settings.offWhenClosed || manual {
+			return
+		}
+
+		if len(open) > 0 {
+			var latest time.Time
+			for _, standby := range open {
+				if standby.After(latest) {
+					latest = standby
+				}
+			}
+			if latest = latest.Add(extension); latest.Before(timer.standbyTime()) {
+				timer.cut(latest)
+				status.setTimer(&timer)
+			}
+			log.Printf("watcher: alarm %d is finished, %d still open until %s\n", msg.Id, len(open), timer.standbyTime().Format(time.TimeOnly))
+			return
+		}
+
+		if settings.closedGrace > 0 {
+			log.Printf("watcher: alarm %d is finished, switching off in %s\n", msg.Id, settings.closedGrace)
+			closing = time.After(settings.closedGrace)
+			return
+		}
+		log.Printf("watcher: alarm %d is finished, switching off\n", msg.Id)
+		end(now)
+	}
+
+	// react reports whether the display was switched on successfully.
+	react := func(d decision) bool {
+		lastOff = d.switchOff
+		if !d.delayUntil.IsZero() {
+			delayed = d.switchOn
+			opened = time.After(time.Until(d.delayUntil))
+			return false
+		}
+		delayed = nil
+		opened = nil
+		return activate(d.switchOn)
+	}
+
+	// reconcile compares the power state of the display with the desired
+	// one and switches again once they differ for longer than the tolerance.
+	var lastReconcile reconcileStatus
+	reconcile := func(res powerResult, now time.Time) {
+		desired := timer.isActive() && delayed == nil
+		rs := reconcileStatus{Desired: powerState(desired), Actual: "unknown", Checked: now}
+		defer func() {
+			status.reconciled(rs)
+			lastReconcile = rs
+		}()
+
+		if res.err != nil {
+			log.Printf("watcher: power check err: %v\n", res.err)
+			rs.Error = res.err.Error()
+			return
+		}
+		rs.Actual = powerState(res.on)
+		if res.on == desired {
+			if rs.Actual != lastReconcile.Actual || rs.Desired != lastReconcile.Desired {
+				log.Printf("watcher: display is %s as desired\n", rs.Actual)
+			}
+			mismatchSince = time.Time{}
+			return
+		}
+
+		if mismatchSince.IsZero() {
+			mismatchSince = now
+		}
+		if tolerated := mismatchSince.Add(settings.reconcile.tolerance); now.Before(tolerated) {
+			log.Printf("watcher: display is %s but should be %s, tolerated until %s\n", rs.Actual, rs.Desired, tolerated.Format(time.TimeOnly))
+			since := mismatchSince
+			rs.MismatchSince = &since
+			return
+		}
+
+		log.Printf("watcher: display is %s but should be %s since %s, switching %s\n", rs.Actual, rs.Desired, mismatchSince.Format(time.TimeOnly), rs.Desired)
+		if desired {
+			switchOn := lastOn
+			if switchOn == nil {
+				switchOn = settings.switchOn
+			}
+			attempt = 1
+			tryOn(switchOn)
+		} else {
+			deactivate()
+		}
+	}
+
+	// power checks run in the background, one at a time, so a slow check
+	// does not hold up alarms. No check is started while a switch on is
+	// retried.
+	checked := make(chan powerResult, 1)
+	checking := false
+	checkedAfter := 0
+	nextCheck := time.Now()
+
+	if d := settings.schedule.apply(settings.ruleDecision(nil), nil, time.Now()); !d.ignore && timer.isActive() {
+		react(d)
+	}
 
 	for {
+		var check <-chan time.Time
+		if settings.reconcile != nil && !checking && retryAt == nil {
+			check = time.After(time.Until(nextCheck))
+		}
+
 		select {
 		case <-ctx.Done():
 			log.Println("watcher: context done")
-			return
+			return ctx.Err()
+
+		case in, ok := <-pipeline:
+			if !ok {
+				if !settings.offOnShutdown {
+					log.Println("watcher: stopped")
+					return nil
+				}
+				log.Println("watcher: stopped, switching off")
+				return deactivate()
+			}
+			msg, received := in.alarm, in.received
+			history.recordAlarm(msg, received)
+
+			if !msg.Deleted && msg.Updated != nil {
+				updated := toTime(msg.Updated)
+				if last, ok := handled[msg.Id]; ok && !updated.After(last) {
+					log.Printf("watcher: alarm %d of %s was handled already\n", msg.Id, updated.Format(time.DateTime))
+					duplicatesDropped.Inc()
+					continue
+				}
+				for id, t := range handled {
+					if received.Sub(t) > HANDLED_RETENTION {
+						delete(handled, id)
+					}
+				}
+				handled[msg.Id] = updated
+			}
+			if snoozed[msg.Id] {
+				log.Printf("watcher: alarm %d is snoozed\n", msg.Id)
+				continue
+			}
+			// closed, archived and deleted alarms never extend the timer or
+			// switch on, offWhenClosed only decides whether they switch off
+			if msg.Deleted || msg.Closed || msg.Archived {
+				closed(msg, received)
+				continue
+			}
+			d := settings.evaluate(msg, received)
+			log.Printf("watcher: alarm %d %s\n", msg.Id, d)
+			if d.ignore {
+				continue
+			}
+			start, err := settings.timestamps.lingerStart(in)
+			if err != nil {
+				log.Printf("watcher: alarm %d rejected: %v\n", msg.Id, err)
+				continue
+			}
+			snoozed = nil
+
+			status.alarmReceived(msg, received)
+			current = msg
+			standby := timer.standbyTime()
+			timer.extend(start, d.lingerTime)
+			if !timer.standbyTime().Equal(standby) {
+				timerAlarm = msg
+				extension = 0
+				manual = false
+			}
+			if timer.isActive() {
+				if standby := start.Add(d.lingerTime); standby.After(open[msg.Id]) {
+					open[msg.Id] = standby
+				}
+			}
+			status.setTimer(&timer)
+			if react(d) && msg.Created != nil && msg.Id != measuredID {
+				measuredID = msg.Id
+				alarmLatency.Observe(time.Since(toTime(msg.Created)).Seconds())
+			}
+
+		case s := <-reload:
+			wasActive := timer.isActive()
+			settings = s
+			// an alarm that is over stays over, even with a longer linger time
+			if wasActive && !manual && (timerAlarm != nil || !restored) {
+				timer.lingerTime = s.lingerTime
+				if timerAlarm != nil {
+					if d := settings.ruleDecision(timerAlarm); !d.ignore {
+						timer.lingerTime = d.lingerTime
+					}
+				}
+				timer.lingerTime += extension
+				timer.save()
+			}
+			status.setTimer(&timer)
+			log.Printf("watcher: settings reloaded, linger time %s\n", timer.lingerTime)
+
+			if delayed != nil {
+				if next, ok := settings.schedule.nextOpen(time.Now()); ok {
+					opened = time.After(time.Until(next))
+				} else {
+					log.Println("watcher: schedule does not open anymore, dropping the delayed alarm")
+					delayed = nil
+					opened = nil
+				}
+			}
 
-		case msg := <-pipeline:
-			timer.update(toTime(msg.Updated))
-			activate()
+			// standby() never fires for a timer that is already expired
+			if wasActive && timer.isExpired() {
+				log.Println("watcher: alarm has expired with the new linger time, switching off")
+				reset()
+				deactivate()
+			}
+
+		case req := <-overrides:
+			res := overrideResult{}
+			now := time.Now()
+			switch req.Action {
+			case overrideOn:
+				lingerTime := settings.lingerTime
+				if req.Minutes > 0 {
+					lingerTime = time.Duration(req.Minutes) * time.Minute
+				}
+				standby := timer.standbyTime()
+				timer.extend(now, lingerTime)
+				if !timer.standbyTime().Equal(standby) {
+					timerAlarm = nil
+					current = nil
+					extension = 0
+					manual = true
+				}
+				delayed = nil
+				opened = nil
+				snoozed = nil
+				log.Printf("watcher: override, switching on until %s\n", timer.standbyTime().Format(time.TimeOnly))
+				lastOff = nil
+				attempt = 1
+				res.err = tryOn(settings.switchOn)
+				res.Message = fmt.Sprintf("switched on until %s", timer.standbyTime().Format(time.TimeOnly))
+
+			case overrideOff:
+				if timer.isActive() {
+					timer.cut(now)
+				}
+				reset()
+				log.Println("watcher: override, switching off")
+				res.err = deactivate()
+				res.Message = "switched off"
+
+			case overrideExtend:
+				if timer.isExpired() {
+					res.err = errNoActiveAlarm
+					break
+				}
+				added := time.Duration(req.Minutes) * time.Minute
+				timer.lingerTime += added
+				timer.save()
+				extension += added
+				log.Printf("watcher: override, extended by %s until %s\n", added, timer.standbyTime().Format(time.TimeOnly))
+				res.Message = fmt.Sprintf("extended until %s", timer.standbyTime().Format(time.TimeOnly))
+
+			case overrideSnooze:
+				if timer.isExpired() {
+					res.err = errNoActiveAlarm
+					break
+				}
+				snoozed = map[int64]bool{}
+				for id := range open {
+					snoozed[id] = true
+				}
+				timer.cut(now)
+				reset()
+				log.Printf("watcher: override, snoozing %d alarms, switching off\n", len(snoozed))
+				res.err = deactivate()
+				res.Message = "snoozed until the next alarm"
+			}
+			status.setTimer(&timer)
+			res.Active = timer.isActive()
+			res.StandbyTime = timer.standbyTime()
+			req.reply <- res
+
+		case <-check:
+			checking = true
+			checkedAfter = actions
+			r := settings.reconcile
+			checkCtx, cancel := context.WithCancel(ctx)
+			cancelCheck = cancel
+			go func() {
+				busy.Lock()
+				defer busy.Unlock()
+				on, err := r.run(checkCtx)
+				checked <- powerResult{on: on, err: err}
+			}()
+
+		case res := <-checked:
+			checking = false
+			cancelCheck()
+			cancelCheck = nil
+			if actions != checkedAfter {
+				log.Println("watcher: display was switched during the power check, checking again")
+				nextCheck = time.Now()
+				continue
+			}
+			// the reconciliation may have been disabled by a reload meanwhile
+			if settings.reconcile != nil {
+				now := time.Now()
+				nextCheck = now.Add(settings.reconcile.interval)
+				reconcile(res, now)
+			}
+
+		case <-retryAt:
+			if timer.isExpired() {
+				retryAt = nil
+				continue
+			}
+			log.Println("watcher: retrying to switch on")
+			tryOn(retryOn)
+
+		case <-closing:
+			closing = nil
+			// an alarm may have been opened or the setting reloaded meanwhile
+			if settings.offWhenClosed && len(open) == 0 && !manual && timer.isActive() {
+				log.Println("watcher: grace time is over, switching off")
+				end(time.Now())
+			}
+
+		case <-opened:
+			log.Println("watcher: schedule opened, switching on the delayed alarm")
+			switchOn := delayed
+			delayed = nil
+			opened = nil
+			activate(switchOn)
 
 		case <-timer.standby():
 			log.Println("watcher: alarm has expired, switching off")
-			if err := switchOff(ctx); err != nil {
-				log.Printf("watcher: switchOff err: %v\n", err)
-			}
+			reset()
+			status.setTimer(&timer)
+			deactivate()
 		}
 	}
 }
 
-func act(ctx context.Context, msg *messages.Alarm, pipeline chan<- *messages.Alarm) error {
+func act(ctx context.Context, d delivery, pipeline chan<- delivery) error {
 	select {
 	case <-ctx.Done():
 		return ctx.Err()
-	case pipeline <- msg:
+	case pipeline <- d:
 	}
 
 	return nil
@@ -114,146 +685,196 @@ func handler(
 	act func(ctx context.Context, msg *messages.Alarm) error) {
 	message := &messages.Alarm{}
 
+	messagesReceived.WithLabelValues("pubsub").Inc()
+	nack := func() {
+		messagesNacked.Inc()
+		msg.Nack()
+	}
+
 	encoding := msg.Attributes["googclient_schemaencoding"]
 
 	if encoding == "BINARY" {
 		if err := proto.Unmarshal(msg.Data, message); err != nil {
 			log.Printf("proto.Unmarshal err: %v\n", err)
-			msg.Nack()
+			decodeFailures.WithLabelValues("pubsub").Inc()
+			nack()
 			return
 		}
 	} else if encoding == "JSON" {
 		if err := protojson.Unmarshal(msg.Data, message); err != nil {
 			log.Printf("proto.Unmarshal err: %v\n", err)
-			msg.Nack()
+			decodeFailures.WithLabelValues("pubsub").Inc()
+			nack()
 			return
 		}
 	} else {
 		log.Printf("Unknown message type(%s), nacking\n", encoding)
-		msg.Nack()
+		decodeFailures.WithLabelValues("pubsub").Inc()
+		nack()
 		return
 	}
 
 	if err := act(ctx, message); err != nil {
 		log.Printf("could not process message, nacking: %v\n", err)
-		msg.Nack()
+		nack()
 		return
 	}
 
 	msg.Ack()
 }
 
-func startListening(ctx context.Context, sub *pubsub.Subscription, watcher func(ctx context.Context, pipeline <-chan *messages.Alarm)) {
-	pipeline := make(chan *messages.Alarm, 10)
+// startListening receives alarms until ctx is done, then closes the pipeline
+// of watcher.
+func startListening(ctx context.Context, sub *pubsub.Subscription, status *daemonStatus, watcher func(pipeline <-chan delivery)) {
+	pipeline := make(chan delivery, 10)
 
-	go watcher(ctx, pipeline)
+	go watcher(pipeline)
 
 	go func() {
 		defer close(pipeline)
 		log.Println("Start receiving messages")
 
-		err := sub.Receive(ctx, func(ctx context.Context, m *pubsub.Message) {
-			handler(ctx, m, func(ctx context.Context, msg *messages.Alarm) error {
-				return act(ctx, msg, pipeline)
+		superviseReceive(ctx, func(ctx context.Context) error {
+			return sub.Receive(ctx, func(ctx context.Context, m *pubsub.Message) {
+				status.messageReceived()
+				handler(ctx, m, func(ctx context.Context, msg *messages.Alarm) error {
+					return act(ctx, delivery{alarm: msg, received: time.Now(), published: m.PublishTime}, pipeline)
+				})
 			})
-		})
-		if err != nil {
-			log.Fatalf("sub.Receive: %s", err)
-		}
+		}, status, defaultReceiveBackoff)
 	}()
 }
 
 func main() {
-	projectID := os.Getenv("PROJECT_ID")
-	if projectID == "" {
-		projectID = pubsub.DetectProjectID
-	}
-	subscriptionName := os.Getenv("SUBSCRIPTION_NAME")
-	if subscriptionName == "" {
-		log.Fatal("SUBSCRIPTION_NAME environment variable is not set")
-	}
-	lingerTime := DEFAULT_LINGER_TIME
-	if val, ok := os.LookupEnv("LINGER_TIME"); ok {
-		v, err := time.ParseDuration(val)
-		lingerTime = v
-		if err != nil {
-			log.Fatalf("LINGER_TIME environment variable is not a valid duration: %v", err)
-		}
+	if len(os.Args) > 1 {
+		os.Exit(runCommand(os.Args[1:]))
 	}
-	switchOnCmd := os.Getenv("SWITCH_ON_CMD")
-	if switchOnCmd == "" {
-		log.Fatal("SWITCH_ON_CMD environment variable is not set")
+
+	configFile := os.Getenv("CONFIG_FILE")
+	cfg, err := loadConfig(configFile)
+	if err != nil {
+		log.Fatal(err)
 	}
-	switchOffCmd := os.Getenv("SWITCH_OFF_CMD")
-	if switchOffCmd == "" {
-		log.Fatal("SWITCH_OFF_CMD environment variable is not set")
+	settings, err := cfg.watcherSettings()
+	if err != nil {
+		log.Fatal(err)
 	}
-	commandTimeout := DEFAULT_COMMAND_TIMEOUT
-	if val, ok := os.LookupEnv("COMMAND_TIMEOUT"); ok {
-		v, err := time.ParseDuration(val)
-		commandTimeout = v
+
+	// ctx is cancelled when the shutdown times out, it kills running
+	// actions. receiveCtx is cancelled first, to stop receiving alarms.
+	ctx, cancel := context.WithCancel(context.Background())
+	receiveCtx, stopReceiving := context.WithCancel(ctx)
+
+	var start func(watcher func(pipeline <-chan delivery))
+	var closers []io.Closer
+
+	status := newDaemonStatus(cfg.Source)
+	registerStatusMetrics(prometheus.DefaultRegisterer, status)
+	// the status server only accepts overrides if they are enabled
+	overrides := make(chan overrideRequest)
+	var statusOverrides chan<- overrideRequest
+	if cfg.Override.Enabled {
+		server, err := startOverrideSocket(cfg.Override.Socket, overrides)
 		if err != nil {
-			log.Fatalf("COMMAND_TIMEOUT environment variable is not a valid duration: %v", err)
+			log.Fatalf("override socket: %v", err)
 		}
+		closers = append(closers, server)
+		statusOverrides = overrides
+	}
+	if cfg.Status.Enabled {
+		closers = append(closers, startStatusServer(cfg.Status.Address, status, statusOverrides, cfg.Override.Token))
 	}
-	lastAlarmFile := os.Getenv("LAST_ALARM_FILE")
 
-	ctx, cancel := context.WithCancel(context.Background())
+	if cfg.Source == "stream" {
+		client := &streamClient{url: cfg.Stream.URL, token: cfg.Stream.Token, client: &http.Client{}}
+		start = func(watcher func(pipeline <-chan delivery)) {
+			startStreaming(receiveCtx, client, status, watcher)
+		}
+	} else {
+		projectID := cfg.PubSub.ProjectID
+		if projectID == "" {
+			projectID = pubsub.DetectProjectID
+		}
 
-	cred, err := google.FindDefaultCredentials(ctx, pubsub.ScopePubSub)
-	if err != nil {
-		log.Fatalf("google.FindDefaultCredentials: %s", err)
-	}
+		cred, err := google.FindDefaultCredentials(ctx, pubsub.ScopePubSub)
+		if err != nil {
+			log.Fatalf("google.FindDefaultCredentials: %s", err)
+		}
 
-	client, err := pubsub.NewClient(ctx, projectID, option.WithCredentials(cred))
-	if err != nil {
-		log.Fatalf("pubsub.NewClient: %s", err)
-	}
-	defer client.Close()
+		client, err := pubsub.NewClient(ctx, projectID, option.WithCredentials(cred))
+		if err != nil {
+			log.Fatalf("pubsub.NewClient: %s", err)
+		}
+		closers = append(closers, client)
 
-	sub := client.Subscription(subscriptionName)
-	if sub == nil {
-		log.Fatalf("client.Subscription(%s) returned nil", subscriptionName)
-	}
+		sub := client.Subscription(cfg.PubSub.Subscription)
+		if sub == nil {
+			log.Fatalf("client.Subscription(%s) returned nil", cfg.PubSub.Subscription)
+		}
 
-	timer := alarmTimer{
-		lingerTime: lingerTime,
-		lastUpdate: loadLastAlarmTime(lastAlarmFile),
-		storeTime:  func(t time.Time) { storeLastAlarmTime(lastAlarmFile, t) },
+		start = func(watcher func(pipeline <-chan delivery)) {
+			startListening(receiveCtx, sub, status, watcher)
+		}
 	}
 
-	startListening(ctx, sub, func(ctx context.Context, pipeline <-chan *messages.Alarm) {
-		watcher(ctx, pipeline, func(ctx context.Context) error {
-			ctx, cancel := context.WithTimeout(ctx, commandTimeout)
-			defer cancel()
+	history, timer := restoreHistory(cfg, status)
+	if history != nil {
+		closers = append(closers, history)
+	}
 
-			log.Println("switching on")
-			return executeCommand(ctx, switchOnCmd)
-		}, func(ctx context.Context) error {
-			ctx, cancel := context.WithTimeout(ctx, commandTimeout)
-			defer cancel()
+	reload := make(chan watcherSettings)
+	if configFile != "" {
+		go watchConfig(receiveCtx, configFile, cfg, reload)
+	}
 
-			log.Println("switching off")
-			return executeCommand(ctx, switchOffCmd)
-		}, timer)
+	done := make(chan error, 1)
+	start(func(pipeline <-chan delivery) {
+		done <- watcher(ctx, pipeline, reload, overrides, settings, timer, status, history)
 	})
 
-	waitForShutdown(client, cancel)
+	os.Exit(waitForShutdown(stopReceiving, cancel, done, time.Duration(cfg.Shutdown.Timeout), closers...))
 }
 
-func storeLastAlarmTime(lastAlarmFile string, t time.Time) {
-	if lastAlarmFile != "" {
-		if f, err := os.OpenFile(lastAlarmFile, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644); err == nil {
-			defer f.Close()
-			if _, err := fmt.Fprintf(f, "%s\n", t.UTC().Format(time.RFC3339)); err != nil {
-				log.Printf("could not write last alarm time to %s: %v\n", lastAlarmFile, err)
-			}
-		} else {
-			log.Printf("could not open last alarm file %s: %v\n", lastAlarmFile, err)
-		}
+// restoreHistory opens the history, if one is configured, and restores the
+// alarm timer and the last alarm from it.
+func restoreHistory(cfg *config, status *daemonStatus) (*historyStore, alarmTimer) {
+	timer := alarmTimer{lastUpdate: time.Unix(0, 0)}
+	if cfg.History.Path == "" {
+		return nil, timer
+	}
+
+	history, err := openHistory(cfg.History.Path, time.Duration(cfg.History.Retention))
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := history.migrateLastAlarmFile(cfg.LastAlarmFile); err != nil {
+		log.Printf("history: could not migrate %s: %v\n", cfg.LastAlarmFile, err)
+	}
+
+	if t, err := history.lastUpdate(); err != nil {
+		log.Printf("history: could not read last update: %v\n", err)
+	} else if !t.IsZero() {
+		log.Printf("last alarm time: %s\n", t)
+		timer.lastUpdate = t
+	}
+	if d, err := history.lingerTime(); err != nil {
+		log.Printf("history: could not read linger time: %v\n", err)
+	} else if d > 0 {
+		log.Printf("last linger time: %s, standby at %s\n", d, timer.lastUpdate.Add(d))
+		timer.lingerTime = d
+	}
+	if msg, received, err := history.lastAlarm(); err != nil {
+		log.Printf("history: could not read last alarm: %v\n", err)
+	} else if msg != nil {
+		status.alarmReceived(msg, received)
 	}
+
+	timer.storeTime = history.storeTimer
+	return history, timer
 }
 
+// loadLastAlarmTime reads the file that was used before the history, it is
+// only needed for the migration.
 func loadLastAlarmTime(lastAlarmFile string) time.Time {
 	if lastAlarmFile == "" {
 		return time.Unix(0, 0)
@@ -278,45 +899,3 @@ func loadLastAlarmTime(lastAlarmFile string) time.Time {
 
 	return time.Unix(0, 0)
 }
-
-func executeCommand(ctx context.Context, command string) error {
-	cmd := exec.CommandContext(ctx, command)
-	out, err := cmd.StdoutPipe()
-	if err != nil {
-		log.Printf("cmd.StdoutPipe: %v\n", err)
-	}
-	go func() {
-		scanner := bufio.NewScanner(out)
-		for scanner.Scan() {
-			log.Printf("[%s] %s", command, scanner.Text())
-		}
-	}()
-	if err := cmd.Start(); err != nil {
-		return fmt.Errorf("cmd.Start(%s): %w", command, err)
-	}
-	if err := cmd.Wait(); err != nil {
-		return fmt.Errorf("cmd.Wait(%s): %w", command, err)
-	}
-
-	return nil
-}
-
-func waitForShutdown(client *pubsub.Client, cancel context.CancelFunc) {
-	c := make(chan os.Signal, 1)
-	signal.Notify(c,
-		os.Interrupt,
-		syscall.SIGTERM,
-		syscall.SIGINT,
-	)
-	<-c
-	log.Println("Shutdown requested")
-
-	cancel()
-	err := client.Close()
-	if err != nil {
-		log.Fatalf("client.Close: %s", err)
-	}
-
-	log.Println("Shutdown complete")
-	os.Exit(0)
-}
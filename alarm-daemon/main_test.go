@@ -0,0 +1,113 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	messages "github.com/CaptainStandby/divera-monitor/proto"
+	"github.com/stretchr/testify/assert"
+)
+
+// testWatcher runs a watcher for a test. Actions made by action report
+// their name on switched.
+type testWatcher struct {
+	pipeline  chan delivery
+	reload    chan watcherSettings
+	overrides chan overrideRequest
+	status    *daemonStatus
+	switched  chan string
+
+	// timer and history are passed to the watcher, the timer is over
+	// unless a test sets another one before start.
+	timer   alarmTimer
+	history *historyStore
+
+	cancel context.CancelFunc
+	done   chan error
+}
+
+func newTestWatcher() *testWatcher {
+	return &testWatcher{
+		pipeline:  make(chan delivery),
+		reload:    make(chan watcherSettings),
+		overrides: make(chan overrideRequest),
+		status:    newDaemonStatus("pubsub"),
+		switched:  make(chan string, 100),
+		timer:     alarmTimer{lastUpdate: time.Unix(0, 0)},
+		done:      make(chan error, 1),
+	}
+}
+
+// startTestWatcher runs a watcher with settings until the test is over, see
+// start.
+func startTestWatcher(t *testing.T, settings watcherSettings) *testWatcher {
+	return newTestWatcher().start(t, settings)
+}
+
+// start runs the watcher with settings until the test is over. Settings
+// without on or off actions get actions that report "on" and "off".
+func (w *testWatcher) start(t *testing.T, settings watcherSettings) *testWatcher {
+	if settings.switchOn == nil {
+		settings.switchOn = w.action("on", nil)
+	}
+	if settings.switchOff == nil {
+		settings.switchOff = w.action("off", nil)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	w.cancel = cancel
+	t.Cleanup(cancel)
+	go func() {
+		w.done <- watcher(ctx, w.pipeline, w.reload, w.overrides, settings, w.timer, w.status, w.history)
+	}()
+	return w
+}
+
+// stop cancels the watcher and returns what it returned.
+func (w *testWatcher) stop() error {
+	w.cancel()
+	return <-w.done
+}
+
+// action reports name on switched and returns err.
+func (w *testWatcher) action(name string, err error) trigger {
+	return func(context.Context, *messages.Alarm) error {
+		w.switched <- name
+		return err
+	}
+}
+
+// override sends an override and waits for the result.
+func (w *testWatcher) override(action string, minutes int) overrideResult {
+	req := overrideRequest{Action: action, Minutes: minutes, reply: make(chan overrideResult, 1)}
+	w.overrides <- req
+	return <-req.reply
+}
+
+// alarm sends an alarm that was updated at updated, received now.
+func (w *testWatcher) alarm(msg *messages.Alarm, updated time.Time) {
+	msg.Updated = &messages.Alarm_Timestamp{Seconds: updated.Unix()}
+	w.pipeline <- delivery{alarm: msg, received: time.Now()}
+}
+
+func TestWatcherDuplicates(t *testing.T) {
+	w := startTestWatcher(t, watcherSettings{lingerTime: time.Hour})
+
+	now := time.Now()
+	w.alarm(&messages.Alarm{Id: 1}, now)
+	assert.Equal(t, "on", <-w.switched)
+	assert.NoError(t, w.override(overrideOff, 0).err)
+	assert.Equal(t, "off", <-w.switched)
+
+	// the webhook and the poller both publish the version, and Pub/Sub may
+	// deliver it again, that does not undo the override
+	w.alarm(&messages.Alarm{Id: 1}, now)
+	w.alarm(&messages.Alarm{Id: 1}, now.Add(-time.Minute))
+	assert.ErrorIs(t, w.override(overrideExtend, 10).err, errNoActiveAlarm)
+	assert.Empty(t, w.switched)
+
+	// an update is handled
+	w.alarm(&messages.Alarm{Id: 1}, now.Add(time.Second))
+	assert.Equal(t, "on", <-w.switched)
+}
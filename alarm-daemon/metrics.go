@@ -0,0 +1,92 @@
+package main
+
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	messagesReceived = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "alarm_daemon_messages_received_total",
+		Help: "Messages received from the alarm source.",
+	}, []string{"source"})
+
+	messagesNacked = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "alarm_daemon_messages_nacked_total",
+		Help: "Pub/Sub messages that were nacked.",
+	})
+
+	decodeFailures = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "alarm_daemon_messages_decode_failures_total",
+		Help: "Messages that could not be decoded into an alarm.",
+	}, []string{"source"})
+
+	switchAttempts = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "alarm_daemon_switch_attempts_total",
+		Help: "Attempts to switch the display on or off.",
+	}, []string{"direction"})
+
+	switchFailures = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "alarm_daemon_switch_failures_total",
+		Help: "Failed attempts to switch the display on or off.",
+	}, []string{"direction"})
+
+	switchGiveUps = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "alarm_daemon_switch_on_give_ups_total",
+		Help: "Times switching on failed after all retry attempts.",
+	})
+
+	actionDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "alarm_daemon_action_duration_seconds",
+		Help:    "Duration of single on and off actions, e.g. commands.",
+		Buckets: []float64{0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60},
+	}, []string{"direction", "action", "result"})
+
+	duplicatesDropped = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "alarm_daemon_duplicates_dropped_total",
+		Help: "Alarm versions that were dropped because they were handled already.",
+	})
+
+	timestampCorrections = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "alarm_daemon_timestamp_corrections_total",
+		Help: "Alarm times that were clamped, rejected or moved for a local clock that is behind.",
+	}, []string{"kind"})
+
+	alarmLatency = promauto.NewHistogram(prometheus.HistogramOpts{
+		Name:    "alarm_daemon_alarm_to_switch_on_seconds",
+		Help:    "Time from the creation of an alarm to the display being switched on.",
+		Buckets: []float64{1, 2, 5, 10, 15, 20, 30, 45, 60, 120, 300},
+	})
+)
+
+// registerStatusMetrics exports the alarm timer kept in status as gauges.
+func registerStatusMetrics(registerer prometheus.Registerer, status *daemonStatus) {
+	registerer.MustRegister(
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Name: "alarm_daemon_alarm_active",
+			Help: "1 while an alarm is active, 0 in standby.",
+		}, func() float64 {
+			if status.timer(time.Now()).Active {
+				return 1
+			}
+			return 0
+		}),
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Name: "alarm_daemon_source_connected",
+			Help: "1 while the alarm source is connected.",
+		}, func() float64 {
+			if status.snapshot(time.Now()).Source.Connected {
+				return 1
+			}
+			return 0
+		}),
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Name: "alarm_daemon_seconds_until_standby",
+			Help: "Seconds until the display is switched off, 0 in standby.",
+		}, func() float64 {
+			return float64(status.timer(time.Now()).SecondsUntilStandby)
+		}),
+	)
+}
@@ -0,0 +1,192 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+const DEFAULT_OVERRIDE_SOCKET = "/run/alarm-daemon/override.sock"
+
+const (
+	overrideOn     = "on"
+	overrideOff    = "off"
+	overrideExtend = "extend"
+	overrideSnooze = "snooze"
+)
+
+var errNoActiveAlarm = errors.New("no alarm is active")
+
+// overrideRequest is sent to the watcher, which answers on reply once it has
+// run the actions.
+type overrideRequest struct {
+	Action  string `json:"action"`
+	Minutes int    `json:"minutes,omitempty"`
+
+	reply chan overrideResult
+}
+
+type overrideResult struct {
+	Message     string    `json:"message"`
+	Active      bool      `json:"active"`
+	StandbyTime time.Time `json:"standby_time"`
+
+	err error
+}
+
+func (r *overrideRequest) validate() error {
+	switch r.Action {
+	case overrideOn, overrideExtend:
+		if r.Minutes < 0 || (r.Action == overrideExtend && r.Minutes == 0) {
+			return fmt.Errorf("%s needs a positive number of minutes", r.Action)
+		}
+	case overrideOff, overrideSnooze:
+		if r.Minutes != 0 {
+			return fmt.Errorf("%s takes no minutes", r.Action)
+		}
+	default:
+		return fmt.Errorf("unknown override %q, must be on, off, extend or snooze", r.Action)
+	}
+	return nil
+}
+
+// newOverrideHandler accepts overrides as JSON on POST /api/override and
+// passes them to the watcher. Other content types are rejected, browsers can
+// send those from any page without asking the daemon first.
+func newOverrideHandler(overrides chan<- overrideRequest) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "overrides must be sent with POST", http.StatusMethodNotAllowed)
+			return
+		}
+		if mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); mediaType != "application/json" {
+			http.Error(w, "overrides must be sent as application/json", http.StatusUnsupportedMediaType)
+			return
+		}
+
+		req := overrideRequest{}
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1024)).Decode(&req); err != nil {
+			http.Error(w, fmt.Sprintf("invalid override: %v", err), http.StatusBadRequest)
+			return
+		}
+		if err := req.validate(); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		req.reply = make(chan overrideResult, 1)
+
+		var res overrideResult
+		select {
+		case <-r.Context().Done():
+			return
+		case overrides <- req:
+		}
+		select {
+		case <-r.Context().Done():
+			return
+		case res = <-req.reply:
+		}
+
+		if errors.Is(res.err, errNoActiveAlarm) {
+			http.Error(w, res.err.Error(), http.StatusConflict)
+			return
+		}
+		if res.err != nil {
+			http.Error(w, res.err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(res); err != nil {
+			log.Printf("override: encode err: %v\n", err)
+		}
+	}
+}
+
+// requireToken only passes requests with token as bearer token.
+func requireToken(token string, next http.Handler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		given, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || subtle.ConstantTimeCompare([]byte(given), []byte(token)) != 1 {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			http.Error(w, "missing or wrong override token", http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, r)
+	}
+}
+
+// startOverrideSocket serves the override API on a Unix socket for the
+// override command. The socket is writable for the group of the daemon.
+func startOverrideSocket(path string, overrides chan<- overrideRequest) (*http.Server, error) {
+	// a stale socket of a previous run would make Listen fail
+	if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+	listener, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.Chmod(path, 0660); err != nil {
+		listener.Close()
+		return nil, err
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("/api/override", newOverrideHandler(overrides))
+	server := &http.Server{Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+	go func() {
+		log.Printf("override: listening on %s\n", path)
+		if err := server.Serve(listener); err != nil && err != http.ErrServerClosed {
+			log.Printf("override: %v\n", err)
+		}
+	}()
+	return server, nil
+}
+
+// sendOverride is used by the override command to talk to a running daemon.
+func sendOverride(ctx context.Context, socket string, req overrideRequest) (string, error) {
+	data, err := json.Marshal(req)
+	if err != nil {
+		return "", err
+	}
+
+	client := &http.Client{Transport: &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			var d net.Dialer
+			return d.DialContext(ctx, "unix", socket)
+		},
+	}}
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, "http://alarm-daemon/api/override", bytes.NewReader(data))
+	if err != nil {
+		return "", err
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+
+	res, err := client.Do(httpReq)
+	if err != nil {
+		return "", fmt.Errorf("could not reach the daemon on %s: %w", socket, err)
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(res.Body, 1024))
+		return "", errors.New(strings.TrimSpace(string(body)))
+	}
+	result := overrideResult{}
+	if err := json.NewDecoder(res.Body).Decode(&result); err != nil {
+		return "", err
+	}
+	return result.Message, nil
+}
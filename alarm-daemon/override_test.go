@@ -0,0 +1,158 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	messages "github.com/CaptainStandby/divera-monitor/proto"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWatcherOverrides(t *testing.T) {
+	w := startTestWatcher(t, watcherSettings{lingerTime: 15 * time.Minute})
+
+	res := w.override(overrideExtend, 10)
+	assert.ErrorIs(t, res.err, errNoActiveAlarm)
+
+	now := time.Now()
+	w.alarm(&messages.Alarm{Id: 1}, now)
+	assert.Equal(t, "on", <-w.switched)
+
+	res = w.override(overrideExtend, 10)
+	assert.NoError(t, res.err)
+	assert.True(t, res.Active)
+	assert.Equal(t, now.Truncate(time.Second).Add(25*time.Minute), res.StandbyTime)
+
+	res = w.override(overrideSnooze, 0)
+	assert.NoError(t, res.err)
+	assert.False(t, res.Active)
+	assert.Equal(t, "off", <-w.switched)
+
+	// updates of the snoozed alarm are ignored, a new alarm is not
+	w.pipeline <- delivery{alarm: &messages.Alarm{Id: 1, Updated: &messages.Alarm_Timestamp{Seconds: now.Unix() + 60}}, received: now.Add(time.Minute)}
+	res = w.override(overrideExtend, 10)
+	assert.ErrorIs(t, res.err, errNoActiveAlarm)
+	w.pipeline <- delivery{alarm: &messages.Alarm{Id: 2, Updated: &messages.Alarm_Timestamp{Seconds: now.Unix() + 60}}, received: now.Add(time.Minute)}
+	assert.Equal(t, "on", <-w.switched)
+
+	res = w.override(overrideOff, 0)
+	assert.NoError(t, res.err)
+	assert.False(t, res.Active)
+	assert.Equal(t, "off", <-w.switched)
+
+	// after a force off, updates switch the display on again
+	w.alarm(&messages.Alarm{Id: 2}, now.Add(61*time.Second))
+	assert.Equal(t, "on", <-w.switched)
+
+	res = w.override(overrideOn, 60)
+	assert.NoError(t, res.err)
+	assert.True(t, res.Active)
+	assert.WithinDuration(t, time.Now().Add(time.Hour), res.StandbyTime, time.Second)
+	assert.Equal(t, "on", <-w.switched)
+}
+
+func TestOverrideHandler(t *testing.T) {
+	overrides := make(chan overrideRequest)
+	go func() {
+		for req := range overrides {
+			if req.Action == overrideSnooze {
+				req.reply <- overrideResult{err: errNoActiveAlarm}
+				continue
+			}
+			req.reply <- overrideResult{Message: "switched " + req.Action}
+		}
+	}()
+	defer close(overrides)
+	handler := newStatusHandler(newDaemonStatus("pubsub"), overrides, "")
+	withToken := newStatusHandler(newDaemonStatus("pubsub"), overrides, "secret")
+
+	tt := []struct {
+		name          string
+		handler       http.Handler
+		method        string
+		contentType   string
+		authorization string
+		body          string
+		code          int
+		result        string
+	}{
+		{name: "on", handler: handler, method: http.MethodPost, body: `{"action": "on"}`, code: http.StatusOK, result: "switched on"},
+		{name: "no active alarm", handler: handler, method: http.MethodPost, body: `{"action": "snooze"}`, code: http.StatusConflict, result: "no alarm is active"},
+		{name: "unknown action", handler: handler, method: http.MethodPost, body: `{"action": "blink"}`, code: http.StatusBadRequest, result: "unknown override"},
+		{name: "extend without minutes", handler: handler, method: http.MethodPost, body: `{"action": "extend"}`, code: http.StatusBadRequest, result: "positive number of minutes"},
+		{name: "invalid json", handler: handler, method: http.MethodPost, body: `on`, code: http.StatusBadRequest, result: "invalid override"},
+		{name: "get", handler: handler, method: http.MethodGet, code: http.StatusMethodNotAllowed},
+		{name: "json with charset", handler: handler, method: http.MethodPost, contentType: "application/json; charset=utf-8", body: `{"action": "on"}`, code: http.StatusOK, result: "switched on"},
+		{name: "form from another site", handler: handler, method: http.MethodPost, contentType: "text/plain", body: `{"action": "on"}`, code: http.StatusUnsupportedMediaType},
+		{name: "without content type", handler: handler, method: http.MethodPost, contentType: "-", body: `{"action": "on"}`, code: http.StatusUnsupportedMediaType},
+		{name: "token", handler: withToken, method: http.MethodPost, authorization: "Bearer secret", body: `{"action": "on"}`, code: http.StatusOK, result: "switched on"},
+		{name: "missing token", handler: withToken, method: http.MethodPost, body: `{"action": "on"}`, code: http.StatusUnauthorized},
+		{name: "wrong token", handler: withToken, method: http.MethodPost, authorization: "Bearer guessed", body: `{"action": "on"}`, code: http.StatusUnauthorized},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest(tc.method, "/api/override", strings.NewReader(tc.body))
+			switch tc.contentType {
+			case "":
+				req.Header.Set("Content-Type", "application/json")
+			case "-":
+			default:
+				req.Header.Set("Content-Type", tc.contentType)
+			}
+			if tc.authorization != "" {
+				req.Header.Set("Authorization", tc.authorization)
+			}
+			rec := httptest.NewRecorder()
+			tc.handler.ServeHTTP(rec, req)
+			assert.Equal(t, tc.code, rec.Code)
+			assert.Contains(t, rec.Body.String(), tc.result)
+		})
+	}
+}
+
+func TestIsLoopback(t *testing.T) {
+	assert.True(t, isLoopback("localhost:8080"))
+	assert.True(t, isLoopback("127.0.0.1:8080"))
+	assert.True(t, isLoopback("[::1]:8080"))
+	assert.False(t, isLoopback(":8080"))
+	assert.False(t, isLoopback("0.0.0.0:8080"))
+	assert.False(t, isLoopback("192.168.1.20:8080"))
+	assert.False(t, isLoopback("kiosk.local:8080"))
+}
+
+func TestOverrideSocket(t *testing.T) {
+	overrides := make(chan overrideRequest)
+	go func() {
+		req := <-overrides
+		req.reply <- overrideResult{Message: "extended by " + (time.Duration(req.Minutes) * time.Minute).String()}
+	}()
+
+	socket := filepath.Join(t.TempDir(), "override.sock")
+	server, err := startOverrideSocket(socket, overrides)
+	assert.NoError(t, err)
+	defer server.Close()
+
+	req, path, err := parseOverride([]string{"-socket", socket, "extend", "5"})
+	assert.NoError(t, err)
+	assert.Equal(t, socket, path)
+
+	message, err := sendOverride(context.Background(), socket, req)
+	assert.NoError(t, err)
+	assert.Equal(t, "extended by 5m0s", message)
+
+	_, err = sendOverride(context.Background(), filepath.Join(t.TempDir(), "missing.sock"), req)
+	assert.ErrorContains(t, err, "could not reach the daemon")
+}
+
+func TestParseOverrideInvalid(t *testing.T) {
+	for _, args := range [][]string{{}, {"on", "soon"}, {"off", "5"}, {"extend"}, {"blink"}, {"on", "1", "2"}} {
+		_, _, err := parseOverride(args)
+		assert.Error(t, err, args)
+	}
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	grpcstatus "google.golang.org/grpc/status"
+)
+
+const RECEIVE_RETRY_INITIAL_DELAY = time.Second
+const RECEIVE_RETRY_MAX_DELAY = time.Minute
+
+// RECEIVE_PERMANENT_RETRY_DELAY is how long to wait after a permanent error.
+// It is tried again nonetheless, the subscription may be created or the
+// permission granted in the meantime.
+const RECEIVE_PERMANENT_RETRY_DELAY = 10 * time.Minute
+
+// RECEIVE_HEALTHY_AFTER resets the backoff once Receive ran this long.
+const RECEIVE_HEALTHY_AFTER = time.Minute
+
+// RECEIVE_CONNECTED_AFTER is how long Receive has to run before the source
+// is reported as connected. Errors like a missing subscription are returned
+// well before.
+const RECEIVE_CONNECTED_AFTER = 10 * time.Second
+
+type receiveBackoff struct {
+	initial, max, permanent, healthyAfter, connectedAfter time.Duration
+}
+
+var defaultReceiveBackoff = receiveBackoff{
+	initial:        RECEIVE_RETRY_INITIAL_DELAY,
+	max:            RECEIVE_RETRY_MAX_DELAY,
+	permanent:      RECEIVE_PERMANENT_RETRY_DELAY,
+	healthyAfter:   RECEIVE_HEALTHY_AFTER,
+	connectedAfter: RECEIVE_CONNECTED_AFTER,
+}
+
+// isPermanent reports whether err from Receive will not go away by itself,
+// like a missing subscription or a missing permission.
+func isPermanent(err error) bool {
+	switch grpcstatus.Code(err) {
+	case codes.NotFound, codes.PermissionDenied, codes.InvalidArgument:
+		return true
+	}
+	return false
+}
+
+// superviseReceive calls receive until ctx is done. It is restarted with
+// exponential backoff after transient errors and with a long delay after
+// permanent ones, so the watcher keeps its timer instead of the daemon
+// exiting.
+func superviseReceive(ctx context.Context, receive func(ctx context.Context) error, status *daemonStatus, backoff receiveBackoff) {
+	delay := backoff.initial
+	for {
+		start := time.Now()
+		done := make(chan error, 1)
+		go func() { done <- receive(ctx) }()
+
+		var err error
+		select {
+		case err = <-done:
+		case <-time.After(backoff.connectedAfter):
+			status.connected()
+			err = <-done
+		}
+		if ctx.Err() != nil {
+			status.disconnected(nil)
+			return
+		}
+		if err == nil {
+			err = errors.New("receive stopped")
+		}
+		status.disconnected(err)
+
+		if time.Since(start) >= backoff.healthyAfter {
+			delay = backoff.initial
+		}
+		wait := delay
+		permanent := isPermanent(err)
+		if permanent {
+			wait = backoff.permanent
+			log.Printf("receive: permanent error: %v, retrying in %s\n", err, wait)
+		} else {
+			log.Printf("receive: %v, retrying in %s\n", err, wait)
+			delay *= 2
+			if delay > backoff.max {
+				delay = backoff.max
+			}
+		}
+		status.retrying(time.Now().Add(wait), permanent)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(wait):
+		}
+	}
+}
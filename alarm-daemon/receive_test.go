@@ -0,0 +1,81 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc/codes"
+	grpcstatus "google.golang.org/grpc/status"
+)
+
+func TestIsPermanent(t *testing.T) {
+	assert.True(t, isPermanent(grpcstatus.Error(codes.NotFound, "subscription does not exist")))
+	assert.True(t, isPermanent(fmt.Errorf("receive: %w", grpcstatus.Error(codes.PermissionDenied, "denied"))))
+	assert.False(t, isPermanent(grpcstatus.Error(codes.Unavailable, "connection reset")))
+	assert.False(t, isPermanent(errors.New("receive stopped")))
+}
+
+func TestSuperviseReceive(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	backoff := receiveBackoff{initial: time.Millisecond, max: 4 * time.Millisecond, permanent: time.Hour, healthyAfter: time.Hour, connectedAfter: time.Hour}
+	status := newDaemonStatus("pubsub")
+	calls := make(chan struct{})
+	errs := []error{
+		grpcstatus.Error(codes.Unavailable, "connection reset"),
+		grpcstatus.Error(codes.Unavailable, "connection reset"),
+		grpcstatus.Error(codes.NotFound, "subscription does not exist"),
+	}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		superviseReceive(ctx, func(ctx context.Context) error {
+			calls <- struct{}{}
+			err := errs[0]
+			errs = errs[1:]
+			return err
+		}, status, backoff)
+	}()
+
+	for i := 0; i < 3; i++ {
+		<-calls
+	}
+	assert.Eventually(t, func() bool {
+		return status.snapshot(time.Now()).Source.State == "failed"
+	}, time.Second, time.Millisecond)
+	source := status.snapshot(time.Now()).Source
+	assert.False(t, source.Connected)
+	assert.Equal(t, "rpc error: code = NotFound desc = subscription does not exist", source.LastError)
+	assert.WithinDuration(t, time.Now().Add(time.Hour), *source.RetryAt, time.Minute)
+
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("superviseReceive did not return after cancel")
+	}
+}
+
+func TestSuperviseReceiveCancel(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	status := newDaemonStatus("pubsub")
+	backoff := defaultReceiveBackoff
+	backoff.connectedAfter = 10 * time.Millisecond
+	superviseReceive(ctx, func(ctx context.Context) error {
+		assert.Equal(t, "disconnected", status.snapshot(time.Now()).Source.State)
+		assert.Eventually(t, func() bool {
+			return status.snapshot(time.Now()).Source.State == "connected"
+		}, time.Second, time.Millisecond)
+		cancel()
+		return nil
+	}, status, backoff)
+
+	source := status.snapshot(time.Now()).Source
+	assert.Equal(t, "disconnected", source.State)
+	assert.Empty(t, source.LastError)
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+const DEFAULT_RECONCILE_TOLERANCE = 2 * time.Minute
+
+// reconcileConfig checks the power state of the display at an interval and
+// switches it again if it differs from what the alarm timer wants for longer
+// than the tolerance. The check is either "cec", which asks the TV with
+// cec-client, or "command", which runs Command: exit code 0 means on, 1 off
+// and anything else unknown.
+type reconcileConfig struct {
+	Interval  duration `yaml:"interval"`
+	Check     string   `yaml:"check"`
+	Command   string   `yaml:"command"`
+	Args      []string `yaml:"args"`
+	Tolerance duration `yaml:"tolerance"`
+}
+
+// powerCheck reports whether the display is on.
+type powerCheck func(ctx context.Context) (bool, error)
+
+type powerResult struct {
+	on  bool
+	err error
+}
+
+type reconciler struct {
+	interval  time.Duration
+	tolerance time.Duration
+	timeout   time.Duration
+	check     powerCheck
+}
+
+// reconcileStatus is shown by the status server.
+type reconcileStatus struct {
+	Desired       string     `json:"desired"`
+	Actual        string     `json:"actual"`
+	Checked       time.Time  `json:"checked"`
+	MismatchSince *time.Time `json:"mismatch_since,omitempty"`
+	Error         string     `json:"error,omitempty"`
+}
+
+func buildReconciler(c *reconcileConfig, defaultTimeout time.Duration) (*reconciler, error) {
+	if c == nil || c.Interval == 0 {
+		return nil, nil
+	}
+	if c.Interval < 0 || c.Tolerance < 0 {
+		return nil, errors.New("reconcile.interval and reconcile.tolerance must not be negative")
+	}
+
+	r := &reconciler{
+		interval:  time.Duration(c.Interval),
+		tolerance: time.Duration(c.Tolerance),
+		timeout:   defaultTimeout,
+	}
+	if r.tolerance == 0 {
+		r.tolerance = DEFAULT_RECONCILE_TOLERANCE
+	}
+
+	switch c.Check {
+	case "", "cec":
+		r.check = checkCEC
+	case "command":
+		if c.Command == "" {
+			return nil, errors.New("reconcile.command is required with check: command")
+		}
+		r.check = func(ctx context.Context) (bool, error) {
+			return checkCommand(ctx, c.Command, c.Args...)
+		}
+	default:
+		return nil, fmt.Errorf("reconcile.check must be cec or command, not %s", c.Check)
+	}
+	return r, nil
+}
+
+// run checks the power state with the command timeout.
+func (r *reconciler) run(ctx context.Context) (bool, error) {
+	ctx, cancel := context.WithTimeout(ctx, r.timeout)
+	defer cancel()
+	return r.check(ctx)
+}
+
+// checkCEC asks the TV for its power status, see infos.md.
+func checkCEC(ctx context.Context) (bool, error) {
+	result, err := executeCommand(ctx, nil, strings.NewReader("pow 0.0.0.0\n"), "cec-client", "-s", "-d", "1")
+	if err != nil {
+		return false, fmt.Errorf("cec-client: %w", err)
+	}
+	return parsePowerStatus(result.stdout())
+}
+
+func parsePowerStatus(lines []string) (bool, error) {
+	for _, line := range lines {
+		status, ok := strings.CutPrefix(strings.TrimSpace(line), "power status: ")
+		if !ok {
+			continue
+		}
+		switch status {
+		case "on":
+			return true, nil
+		case "standby":
+			return false, nil
+		}
+		return false, fmt.Errorf("power status is %s", status)
+	}
+	return false, errors.New("no power status in the output of cec-client")
+}
+
+func checkCommand(ctx context.Context, command string, args ...string) (bool, error) {
+	result, err := executeCommand(ctx, nil, nil, command, args...)
+	switch {
+	case err == nil:
+		return true, nil
+	case result.ExitCode == 1 && !result.TimedOut:
+		return false, nil
+	}
+	return false, fmt.Errorf("%s: %w", command, err)
+}
+
+func powerState(on bool) string {
+	if on {
+		return "on"
+	}
+	return "off"
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	messages "github.com/CaptainStandby/divera-monitor/proto"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParsePowerStatus(t *testing.T) {
+	on, err := parsePowerStatus([]string{"opening a connection to the CEC adapter...", "power status: on"})
+	assert.NoError(t, err)
+	assert.True(t, on)
+
+	on, err = parsePowerStatus([]string{"opening a connection to the CEC adapter...", "power status: standby"})
+	assert.NoError(t, err)
+	assert.False(t, on)
+
+	_, err = parsePowerStatus([]string{"power status: in transition from standby to on"})
+	assert.EqualError(t, err, "power status is in transition from standby to on")
+
+	_, err = parsePowerStatus([]string{"opening a connection to the CEC adapter..."})
+	assert.Error(t, err)
+}
+
+func TestCheckCommand(t *testing.T) {
+	on, err := checkCommand(context.Background(), "/bin/sh", "-c", "exit 0")
+	assert.NoError(t, err)
+	assert.True(t, on)
+
+	on, err = checkCommand(context.Background(), "/bin/sh", "-c", "exit 1")
+	assert.NoError(t, err)
+	assert.False(t, on)
+
+	_, err = checkCommand(context.Background(), "/bin/sh", "-c", "exit 2")
+	assert.ErrorContains(t, err, "exit code 2")
+}
+
+func TestBuildReconciler(t *testing.T) {
+	r, err := buildReconciler(&reconcileConfig{}, time.Second)
+	assert.NoError(t, err)
+	assert.Nil(t, r)
+
+	r, err = buildReconciler(&reconcileConfig{Interval: duration(time.Minute)}, time.Second)
+	assert.NoError(t, err)
+	assert.Equal(t, DEFAULT_RECONCILE_TOLERANCE, r.tolerance)
+
+	_, err = buildReconciler(&reconcileConfig{Interval: duration(time.Minute), Check: "command"}, time.Second)
+	assert.ErrorContains(t, err, "reconcile.command is required")
+	_, err = buildReconciler(&reconcileConfig{Interval: duration(time.Minute), Check: "ping"}, time.Second)
+	assert.ErrorContains(t, err, "must be cec or command")
+}
+
+func TestWatcherReconcile(t *testing.T) {
+	var on atomic.Bool
+	w := newTestWatcher()
+	switchOn, switchOff := w.action("on", nil), w.action("off", nil)
+	w.start(t, watcherSettings{
+		lingerTime: time.Hour,
+		switchOn:   func(ctx context.Context, msg *messages.Alarm) error { on.Store(true); return switchOn(ctx, msg) },
+		switchOff:  func(ctx context.Context, msg *messages.Alarm) error { on.Store(false); return switchOff(ctx, msg) },
+		reconcile: &reconciler{
+			interval:  time.Millisecond,
+			tolerance: 20 * time.Millisecond,
+			timeout:   time.Second,
+			check:     func(context.Context) (bool, error) { return on.Load(), nil },
+		},
+	})
+	w.alarm(&messages.Alarm{Id: 1}, time.Now())
+	assert.Equal(t, "on", <-w.switched)
+
+	// switched off by hand during the alarm, switched on again after the tolerance
+	off := time.Now()
+	on.Store(false)
+	assert.Equal(t, "on", <-w.switched)
+	assert.GreaterOrEqual(t, time.Since(off), 20*time.Millisecond)
+
+	assert.Eventually(t, func() bool {
+		r := w.status.snapshot(time.Now()).Reconcile
+		return r != nil && r.Desired == "on" && r.Actual == "on" && r.MismatchSince == nil
+	}, time.Second, time.Millisecond)
+}
+
+func TestWatcherReconcileSerialized(t *testing.T) {
+	// running is 1 while cec-client would run, overlaps make it 2
+	var running, overlaps, checks atomic.Int32
+	busy := func() {
+		if running.Add(1) > 1 {
+			overlaps.Add(1)
+		}
+		time.Sleep(5 * time.Millisecond)
+		running.Add(-1)
+	}
+
+	var on atomic.Bool
+	w := startTestWatcher(t, watcherSettings{
+		lingerTime: time.Hour,
+		switchOn:   func(context.Context, *messages.Alarm) error { busy(); on.Store(true); return nil },
+		switchOff:  func(context.Context, *messages.Alarm) error { busy(); on.Store(false); return nil },
+		reconcile: &reconciler{
+			interval:  time.Millisecond,
+			tolerance: time.Hour,
+			timeout:   time.Second,
+			check:     func(context.Context) (bool, error) { checks.Add(1); busy(); return on.Load(), nil },
+		},
+	})
+
+	for i := 0; i < 10; i++ {
+		for _, action := range []string{overrideOn, overrideOff} {
+			assert.NoError(t, w.override(action, 0).err)
+			time.Sleep(2 * time.Millisecond)
+		}
+	}
+	assert.Greater(t, checks.Load(), int32(1))
+	assert.Zero(t, overlaps.Load())
+}
+
+func TestWatcherReconcileCancelled(t *testing.T) {
+	// the check hangs like a cec-client that does not get an answer
+	started := make(chan struct{}, 10)
+	w := startTestWatcher(t, watcherSettings{
+		lingerTime: time.Hour,
+		reconcile: &reconciler{
+			interval:  time.Hour,
+			tolerance: time.Hour,
+			timeout:   time.Minute,
+			check: func(ctx context.Context) (bool, error) {
+				started <- struct{}{}
+				<-ctx.Done()
+				return false, ctx.Err()
+			},
+		},
+	})
+	<-started
+
+	// the alarm does not wait for the check, which is cancelled and run again
+	start := time.Now()
+	w.alarm(&messages.Alarm{Id: 1}, time.Now())
+	assert.Equal(t, "on", <-w.switched)
+	assert.Less(t, time.Since(start), time.Second)
+	select {
+	case <-started:
+	case <-time.After(time.Second):
+		t.Fatal("the power check was not run again")
+	}
+	assert.Nil(t, w.status.snapshot(time.Now()).Reconcile)
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	messages "github.com/CaptainStandby/divera-monitor/proto"
+)
+
+const DEFAULT_RETRY_INITIAL_DELAY = 5 * time.Second
+const DEFAULT_RETRY_MAX_DELAY = time.Minute
+
+// retryConfig repeats a failed switch on with exponential backoff. If verify
+// is set, it runs after every attempt and its success counts as switched on,
+// even if an on action failed. The alert actions run once all attempts
+// failed.
+type retryConfig struct {
+	Attempts     int               `yaml:"attempts"`
+	InitialDelay duration          `yaml:"initial_delay"`
+	MaxDelay     duration          `yaml:"max_delay"`
+	Verify       *actionConfig     `yaml:"verify"`
+	Alert        *actionListConfig `yaml:"alert"`
+}
+
+// retryPolicy is used by the watcher. The zero value tries once and has
+// neither verify nor alert.
+type retryPolicy struct {
+	attempts     int
+	initialDelay time.Duration
+	maxDelay     time.Duration
+	verify       trigger
+	alert        trigger
+}
+
+func buildRetryPolicy(c retryConfig, defaultTimeout time.Duration) (retryPolicy, error) {
+	p := retryPolicy{
+		attempts:     c.Attempts,
+		initialDelay: time.Duration(c.InitialDelay),
+		maxDelay:     time.Duration(c.MaxDelay),
+	}
+	if p.attempts < 0 {
+		return p, errors.New("retry.attempts must not be negative")
+	}
+	if p.initialDelay < 0 || p.maxDelay < 0 {
+		return p, errors.New("retry delays must not be negative")
+	}
+	if p.attempts == 0 {
+		p.attempts = 1
+	}
+	if p.initialDelay == 0 {
+		p.initialDelay = DEFAULT_RETRY_INITIAL_DELAY
+	}
+	if p.maxDelay == 0 {
+		p.maxDelay = DEFAULT_RETRY_MAX_DELAY
+	}
+
+	if c.Verify != nil {
+		a, err := buildAction(*c.Verify)
+		if err != nil {
+			return p, fmt.Errorf("retry.verify: %w", err)
+		}
+		timeout := time.Duration(c.Verify.Timeout)
+		if timeout <= 0 {
+			timeout = defaultTimeout
+		}
+		verify := timedAction{Action: a, timeout: timeout, label: c.Verify.Type}
+		p.verify = func(ctx context.Context, msg *messages.Alarm) error {
+			return runAction(ctx, "verify", verify, msg)
+		}
+	}
+	if c.Alert != nil {
+		list, err := buildActionList("alert", *c.Alert, defaultTimeout)
+		if err != nil {
+			return p, err
+		}
+		p.alert = list.run
+	}
+	return p, nil
+}
+
+// delay returns the time to wait after the given failed attempt, starting
+// with 1.
+func (p *retryPolicy) delay(attempt int) time.Duration {
+	d := p.initialDelay
+	for i := 1; i < attempt && d < p.maxDelay; i++ {
+		d *= 2
+	}
+	if d > p.maxDelay {
+		d = p.maxDelay
+	}
+	return d
+}
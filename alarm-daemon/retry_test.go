@@ -0,0 +1,107 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	messages "github.com/CaptainStandby/divera-monitor/proto"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRetryDelay(t *testing.T) {
+	p, err := buildRetryPolicy(retryConfig{Attempts: 6, InitialDelay: duration(time.Second), MaxDelay: duration(5 * time.Second)}, time.Second)
+	assert.NoError(t, err)
+
+	var delays []time.Duration
+	for attempt := 1; attempt < 6; attempt++ {
+		delays = append(delays, p.delay(attempt))
+	}
+	assert.Equal(t, []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 5 * time.Second, 5 * time.Second}, delays)
+
+	p, err = buildRetryPolicy(retryConfig{}, time.Second)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, p.attempts)
+	assert.Equal(t, DEFAULT_RETRY_INITIAL_DELAY, p.delay(1))
+
+	_, err = buildRetryPolicy(retryConfig{Attempts: -1}, time.Second)
+	assert.Error(t, err)
+	_, err = buildRetryPolicy(retryConfig{Verify: &actionConfig{Type: "telnet"}}, time.Second)
+	assert.ErrorContains(t, err, "retry.verify")
+}
+
+func TestWatcherRetry(t *testing.T) {
+	tt := []struct {
+		name     string
+		failures int
+		verifyOK bool
+		verify   error
+		switched []string
+	}{
+		{
+			name:     "succeeds on the last attempt",
+			failures: 2,
+			switched: []string{"on", "verify", "on", "verify", "on", "verify"},
+		},
+		{
+			name:     "verify ends the retries",
+			failures: 3,
+			verifyOK: true,
+			switched: []string{"on", "verify"},
+		},
+		{
+			name:     "alert after all attempts",
+			verify:   errors.New("standby"),
+			switched: []string{"on", "verify", "on", "verify", "on", "verify", "alert"},
+		},
+	}
+
+	for _, tc := range tt {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			w := newTestWatcher()
+			failures := tc.failures
+			var lastErr error
+			w.start(t, watcherSettings{
+				lingerTime: time.Hour,
+				switchOn: func(context.Context, *messages.Alarm) error {
+					w.switched <- "on"
+					lastErr = nil
+					if failures > 0 {
+						failures--
+						lastErr = errors.New("cec-client busy")
+					}
+					return lastErr
+				},
+				switchOff: w.action("off", nil),
+				retry: retryPolicy{
+					attempts:     3,
+					initialDelay: time.Millisecond,
+					maxDelay:     time.Millisecond,
+					verify: func(context.Context, *messages.Alarm) error {
+						w.switched <- "verify"
+						if tc.verify != nil || tc.verifyOK {
+							return tc.verify
+						}
+						return lastErr
+					},
+					alert: w.action("alert", nil),
+				},
+			})
+			w.alarm(&messages.Alarm{Id: 1}, time.Now())
+
+			var got []string
+			for range tc.switched {
+				got = append(got, <-w.switched)
+			}
+			assert.Equal(t, tc.switched, got)
+
+			select {
+			case s := <-w.switched:
+				t.Errorf("unexpected %s", s)
+			case <-time.After(20 * time.Millisecond):
+			}
+		})
+	}
+}
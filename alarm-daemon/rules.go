@@ -0,0 +1,195 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	messages "github.com/CaptainStandby/divera-monitor/proto"
+)
+
+// ruleConfig decides how the daemon reacts to the alarms it matches. The
+// first matching rule wins; alarms that match no rule use the defaults.
+// Actions replace the on actions, OffActions the off actions once the
+// display was switched on for the rule.
+type ruleConfig struct {
+	Name  string      `yaml:"name"`
+	Match matchConfig `yaml:"match"`
+
+	Ignore     bool              `yaml:"ignore"`
+	LingerTime duration          `yaml:"linger_time"`
+	Actions    *actionListConfig `yaml:"actions"`
+	OffActions *actionListConfig `yaml:"off_actions"`
+}
+
+// matchConfig matches if all given criteria match. A list matches if any of
+// its entries does. Keywords are matched case-insensitively.
+type matchConfig struct {
+	Title            []string `yaml:"title"`
+	Text             []string `yaml:"text"`
+	Priority         *bool    `yaml:"priority"`
+	NotificationType []int32  `yaml:"notification_type"`
+	Groups           []int64  `yaml:"groups"`
+	Vehicles         []int64  `yaml:"vehicles"`
+}
+
+type rule struct {
+	name       string
+	match      matchConfig
+	ignore     bool
+	lingerTime time.Duration
+	switchOn   trigger
+	switchOff  trigger
+}
+
+// ruleSet is evaluated by the watcher for every alarm.
+type ruleSet struct {
+	rules []rule
+}
+
+// decision is the outcome of evaluating an alarm. rule is empty if no rule
+// matched. switchOff is nil for the default off actions.
+type decision struct {
+	rule       string
+	ignore     bool
+	lingerTime time.Duration
+	switchOn   trigger
+	actions    string
+	switchOff  trigger
+	offActions string
+
+	// set by the schedule outside of its hours
+	quiet      bool
+	delayUntil time.Time
+}
+
+func buildRules(configs []ruleConfig, defaultTimeout time.Duration) (*ruleSet, error) {
+	rs := &ruleSet{}
+
+	for i, c := range configs {
+		name := c.Name
+		if name == "" {
+			name = fmt.Sprintf("rule %d", i+1)
+		}
+		if c.LingerTime < 0 {
+			return nil, fmt.Errorf("%s: linger_time must not be negative", name)
+		}
+		if c.Ignore && (c.LingerTime != 0 || c.Actions != nil) {
+			return nil, fmt.Errorf("%s: ignore cannot be combined with linger_time or actions", name)
+		}
+		if c.Ignore && c.OffActions != nil {
+			return nil, fmt.Errorf("%s: ignore cannot be combined with off_actions", name)
+		}
+
+		r := rule{name: name, match: c.Match, ignore: c.Ignore, lingerTime: time.Duration(c.LingerTime)}
+		if c.Actions != nil {
+			list, err := buildActionList(name, *c.Actions, defaultTimeout)
+			if err != nil {
+				return nil, err
+			}
+			r.switchOn = list.run
+		}
+		if c.OffActions != nil {
+			list, err := buildActionList(name+" off", *c.OffActions, defaultTimeout)
+			if err != nil {
+				return nil, err
+			}
+			r.switchOff = list.run
+		}
+		rs.rules = append(rs.rules, r)
+	}
+	return rs, nil
+}
+
+// evaluate changes d according to the first matching rule. It is free of side
+// effects, so it can be used for a dry run.
+func (rs *ruleSet) evaluate(msg *messages.Alarm, d decision) decision {
+	if rs == nil || msg == nil {
+		return d
+	}
+	for _, r := range rs.rules {
+		if !r.match.matches(msg) {
+			continue
+		}
+		d.rule = r.name
+		d.ignore = r.ignore
+		if r.lingerTime > 0 {
+			d.lingerTime = r.lingerTime
+		}
+		if r.switchOn != nil {
+			d.switchOn = r.switchOn
+			d.actions = r.name
+		}
+		if r.switchOff != nil {
+			d.switchOff = r.switchOff
+			d.offActions = r.name
+		}
+		return d
+	}
+	return d
+}
+
+func (d decision) String() string {
+	rule := "no rule"
+	if d.rule != "" {
+		rule = fmt.Sprintf("rule %q", d.rule)
+	}
+	if d.ignore && d.quiet {
+		return fmt.Sprintf("%s: suppressed outside of the schedule", rule)
+	}
+	if d.ignore {
+		return fmt.Sprintf("ignored by %s", rule)
+	}
+	when := "switch on"
+	if !d.delayUntil.IsZero() {
+		when = fmt.Sprintf("switch on at %s", d.delayUntil.Format("2006-01-02 15:04"))
+	}
+	s := fmt.Sprintf("%s: %s with %s actions, linger %s", rule, when, d.actions, d.lingerTime)
+	if d.offActions != "" {
+		s += fmt.Sprintf(", switch off with %s actions", d.offActions)
+	}
+	return s
+}
+
+func (m *matchConfig) matches(msg *messages.Alarm) bool {
+	if len(m.Title) > 0 && !containsKeyword(msg.Title, m.Title) {
+		return false
+	}
+	if len(m.Text) > 0 && !containsKeyword(msg.Text, m.Text) {
+		return false
+	}
+	if m.Priority != nil && *m.Priority != msg.Priority {
+		return false
+	}
+	if len(m.NotificationType) > 0 && !containsAny([]int32{msg.NotificationType}, m.NotificationType) {
+		return false
+	}
+	if len(m.Groups) > 0 && !containsAny(msg.Group, m.Groups) {
+		return false
+	}
+	if len(m.Vehicles) > 0 && !containsAny(msg.Vehicle, m.Vehicles) {
+		return false
+	}
+	return true
+}
+
+func containsKeyword(s string, keywords []string) bool {
+	s = strings.ToLower(s)
+	for _, k := range keywords {
+		if strings.Contains(s, strings.ToLower(k)) {
+			return true
+		}
+	}
+	return false
+}
+
+func containsAny[T comparable](values, wanted []T) bool {
+	for _, v := range values {
+		for _, w := range wanted {
+			if v == w {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	messages "github.com/CaptainStandby/divera-monitor/proto"
+	"github.com/stretchr/testify/assert"
+)
+
+const rulesConfig = `
+pubsub:
+  subscription: divera-alarm
+linger_time: 15m
+actions:
+  on:
+    actions: [{ type: command, command: on.sh }]
+  off:
+    actions: [{ type: command, command: off.sh }]
+rules:
+  - name: test alarms
+    match:
+      title: [probealarm, test]
+    ignore: true
+  - name: priority
+    match:
+      priority: true
+    linger_time: 30m
+    actions:
+      actions: [{ type: command, command: on.sh }, { type: command, command: siren.sh }]
+    off_actions:
+      actions: [{ type: command, command: off.sh }, { type: command, command: siren-off.sh }]
+  - name: info
+    match:
+      notification_type: [4]
+    linger_time: 5m
+  - name: hlf
+    match:
+      vehicles: [4711]
+      text: [verkehrsunfall]
+    linger_time: 20m
+`
+
+func TestRules(t *testing.T) {
+	c, err := loadConfigFile(writeConfig(t, rulesConfig))
+	assert.NoError(t, err)
+	settings, err := c.watcherSettings()
+	assert.NoError(t, err)
+
+	tt := []struct {
+		name   string
+		alarm  *messages.Alarm
+		result string
+	}{
+		{
+			name:   "no rule",
+			alarm:  &messages.Alarm{Title: "B3 Wohnungsbrand"},
+			result: "no rule: switch on with default actions, linger 15m0s",
+		},
+		{
+			name:   "keyword is case-insensitive",
+			alarm:  &messages.Alarm{Title: "PROBEALARM", Priority: true},
+			result: `ignored by rule "test alarms"`,
+		},
+		{
+			name:   "priority",
+			alarm:  &messages.Alarm{Title: "B3 Wohnungsbrand", Priority: true},
+			result: `rule "priority": switch on with priority actions, linger 30m0s, switch off with priority actions`,
+		},
+		{
+			name:   "notification type",
+			alarm:  &messages.Alarm{Title: "Dienstabend", NotificationType: 4},
+			result: `rule "info": switch on with default actions, linger 5m0s`,
+		},
+		{
+			name:   "all criteria must match",
+			alarm:  &messages.Alarm{Title: "TH1", Text: "Verkehrsunfall mit PKW", Vehicle: []int64{12, 4711}},
+			result: `rule "hlf": switch on with default actions, linger 20m0s`,
+		},
+		{
+			name:   "one criterion is not enough",
+			alarm:  &messages.Alarm{Title: "TH1", Text: "Ölspur", Vehicle: []int64{4711}},
+			result: "no rule: switch on with default actions, linger 15m0s",
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.result, settings.evaluate(tc.alarm, time.Now()).String())
+		})
+	}
+}
+
+func TestRulesInvalid(t *testing.T) {
+	_, err := loadConfigFile(writeConfig(t, rulesConfig+`
+  - name: broken
+    ignore: true
+    linger_time: 5m
+`))
+	assert.ErrorContains(t, err, "broken: ignore cannot be combined with linger_time or actions")
+
+	_, err = loadConfigFile(writeConfig(t, rulesConfig+`
+  - name: broken
+    ignore: true
+    off_actions:
+      actions: [{ type: command, command: off.sh }]
+`))
+	assert.ErrorContains(t, err, "broken: ignore cannot be combined with off_actions")
+}
+
+func TestDryRun(t *testing.T) {
+	c, err := loadConfigFile(writeConfig(t, rulesConfig))
+	assert.NoError(t, err)
+	settings, err := c.watcherSettings()
+	assert.NoError(t, err)
+
+	var out bytes.Buffer
+	err = dryRun(&settings, []string{"-"}, strings.NewReader(`{"id": "42", "title": "Probealarm"}`), &out)
+	assert.NoError(t, err)
+	assert.Equal(t, "-: alarm 42 \"Probealarm\" ignored by rule \"test alarms\"\n", out.String())
+
+	err = dryRun(&settings, []string{"-"}, strings.NewReader(`{"id": "forty-two"}`), &out)
+	assert.ErrorContains(t, err, "- is not a valid alarm")
+}
+
+func TestWatcherRules(t *testing.T) {
+	w := newTestWatcher()
+	priority := true
+	w.start(t, watcherSettings{
+		lingerTime: 15 * time.Minute,
+		rules: &ruleSet{rules: []rule{
+			{name: "test", match: matchConfig{Title: []string{"test"}}, ignore: true},
+			{name: "priority", match: matchConfig{Priority: &priority}, lingerTime: time.Minute},
+			{name: "info", match: matchConfig{NotificationType: []int32{4}}, lingerTime: time.Hour, switchOn: w.action("info", nil)},
+		}},
+	})
+
+	now := time.Now()
+	w.alarm(&messages.Alarm{Id: 1, Title: "Test"}, now)
+	w.alarm(&messages.Alarm{Id: 2, NotificationType: 4}, now)
+	assert.Equal(t, "info", <-w.switched)
+
+	// the shorter linger time of a later alarm does not cut off the first
+	w.alarm(&messages.Alarm{Id: 3, Priority: true}, now.Add(time.Second))
+	assert.Equal(t, "on", <-w.switched)
+	timer := w.status.timer(time.Now())
+	assert.Equal(t, "1h0m0s", timer.LingerTime)
+	assert.Equal(t, now.Truncate(time.Second).Add(time.Hour), timer.StandbyTime)
+}
+
+func TestWatcherRuleOffActions(t *testing.T) {
+	w := newTestWatcher()
+	priority := true
+	w.start(t, watcherSettings{
+		lingerTime: 15 * time.Minute,
+		rules: &ruleSet{rules: []rule{
+			{name: "priority", match: matchConfig{Priority: &priority}, switchOn: w.action("siren", nil), switchOff: w.action("siren off", nil)},
+		}},
+	})
+
+	now := time.Now()
+	w.alarm(&messages.Alarm{Id: 1, Priority: true}, now)
+	assert.Equal(t, "siren", <-w.switched)
+	assert.NoError(t, w.override(overrideOff, 0).err)
+	assert.Equal(t, "siren off", <-w.switched)
+
+	// the display was last switched on with the default actions
+	w.alarm(&messages.Alarm{Id: 1, Priority: true}, now.Add(time.Second))
+	assert.Equal(t, "siren", <-w.switched)
+	w.alarm(&messages.Alarm{Id: 2}, now.Add(2*time.Second))
+	assert.Equal(t, "on", <-w.switched)
+	assert.NoError(t, w.override(overrideOff, 0).err)
+	assert.Equal(t, "off", <-w.switched)
+}
@@ -0,0 +1,267 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	messages "github.com/CaptainStandby/divera-monitor/proto"
+)
+
+// scheduleConfig restricts the hours in which the display is switched on.
+// Outside of them alarms are suppressed, delayed until the next opening or
+// only run the restricted actions. Priority alarms are not affected unless
+// priority_override is false.
+type scheduleConfig struct {
+	Timezone         string            `yaml:"timezone"`
+	Hours            []windowConfig    `yaml:"hours"`
+	Holidays         []string          `yaml:"holidays"`
+	HolidaysFile     string            `yaml:"holidays_file"`
+	Outside          string            `yaml:"outside"`
+	RestrictActions  *actionListConfig `yaml:"restrict_actions"`
+	PriorityOverride *bool             `yaml:"priority_override"`
+}
+
+// windowConfig is open from From to To on the given days. To may be before
+// From for windows that span midnight. The day "holiday" stands for the dates
+// in the holiday list, which do not count as their weekday.
+type windowConfig struct {
+	Days []string `yaml:"days"`
+	From string   `yaml:"from"`
+	To   string   `yaml:"to"`
+}
+
+const (
+	outsideSuppress = "suppress"
+	outsideDelay    = "delay"
+	outsideRestrict = "restrict"
+)
+
+var weekdays = map[string]time.Weekday{
+	"sun": time.Sunday,
+	"mon": time.Monday,
+	"tue": time.Tuesday,
+	"wed": time.Wednesday,
+	"thu": time.Thursday,
+	"fri": time.Friday,
+	"sat": time.Saturday,
+}
+
+type window struct {
+	days     [7]bool
+	holidays bool
+	// minutes since midnight
+	from, to int
+}
+
+type schedule struct {
+	location         *time.Location
+	windows          []window
+	holidays         map[string]bool
+	outside          string
+	restrictOn       trigger
+	priorityOverride bool
+}
+
+func buildSchedule(c *scheduleConfig, defaultTimeout time.Duration) (*schedule, error) {
+	if c == nil || len(c.Hours) == 0 {
+		return nil, nil
+	}
+
+	s := &schedule{location: time.Local, holidays: map[string]bool{}, priorityOverride: true}
+	if c.Timezone != "" {
+		loc, err := time.LoadLocation(c.Timezone)
+		if err != nil {
+			return nil, fmt.Errorf("schedule.timezone: %w", err)
+		}
+		s.location = loc
+	}
+	if c.PriorityOverride != nil {
+		s.priorityOverride = *c.PriorityOverride
+	}
+
+	for i, w := range c.Hours {
+		parsed, err := parseWindow(w)
+		if err != nil {
+			return nil, fmt.Errorf("schedule.hours %d: %w", i+1, err)
+		}
+		s.windows = append(s.windows, parsed)
+	}
+
+	holidays := c.Holidays
+	if c.HolidaysFile != "" {
+		fromFile, err := readHolidays(c.HolidaysFile)
+		if err != nil {
+			return nil, fmt.Errorf("schedule.holidays_file: %w", err)
+		}
+		holidays = append(holidays, fromFile...)
+	}
+	for _, h := range holidays {
+		if _, err := time.Parse(time.DateOnly, h); err != nil {
+			return nil, fmt.Errorf("schedule.holidays: %q is not a date like 2006-01-02", h)
+		}
+		s.holidays[h] = true
+	}
+
+	switch c.Outside {
+	case "", outsideSuppress:
+		s.outside = outsideSuppress
+	case outsideDelay:
+		s.outside = outsideDelay
+	case outsideRestrict:
+		if c.RestrictActions == nil {
+			return nil, errors.New("schedule.restrict_actions are required with outside: restrict")
+		}
+		list, err := buildActionList("restricted", *c.RestrictActions, defaultTimeout)
+		if err != nil {
+			return nil, err
+		}
+		s.outside = outsideRestrict
+		s.restrictOn = list.run
+	default:
+		return nil, fmt.Errorf("schedule.outside must be suppress, delay or restrict, not %s", c.Outside)
+	}
+
+	return s, nil
+}
+
+func parseWindow(c windowConfig) (window, error) {
+	var w window
+	if len(c.Days) == 0 {
+		return w, errors.New("days are required")
+	}
+	for _, d := range c.Days {
+		d = strings.ToLower(d)
+		if d == "holiday" {
+			w.holidays = true
+			continue
+		}
+		day, ok := weekdays[d]
+		if !ok {
+			return w, fmt.Errorf("unknown day %q", d)
+		}
+		w.days[day] = true
+	}
+
+	var err error
+	if w.from, err = parseClock(c.From); err != nil {
+		return w, fmt.Errorf("from: %w", err)
+	}
+	if w.to, err = parseClock(c.To); err != nil {
+		return w, fmt.Errorf("to: %w", err)
+	}
+	if w.from == w.to {
+		return w, errors.New("from and to must differ")
+	}
+	return w, nil
+}
+
+// parseClock returns the minutes since midnight of a time like "06:30".
+// "24:00" is allowed as end of the day.
+func parseClock(s string) (int, error) {
+	var h, m int
+	if _, err := fmt.Sscanf(s, "%d:%d", &h, &m); err != nil || h < 0 || m < 0 || m > 59 || h*60+m > 24*60 {
+		return 0, fmt.Errorf("%q is not a time like 06:30", s)
+	}
+	return h*60 + m, nil
+}
+
+func readHolidays(path string) ([]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var dates []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line, _, _ := strings.Cut(scanner.Text(), "#")
+		if line = strings.TrimSpace(line); line != "" {
+			dates = append(dates, line)
+		}
+	}
+	return dates, scanner.Err()
+}
+
+// appliesTo reports whether the window is configured for the given day.
+// Holidays only use windows with the day "holiday".
+func (w *window) appliesTo(day time.Time, holiday bool) bool {
+	if holiday {
+		return w.holidays
+	}
+	return w.days[day.Weekday()]
+}
+
+// isOpen reports whether the display may be switched on at t. A nil schedule
+// is always open.
+func (s *schedule) isOpen(t time.Time) bool {
+	if s == nil {
+		return true
+	}
+	t = t.In(s.location)
+	minute := t.Hour()*60 + t.Minute()
+	yesterday := t.AddDate(0, 0, -1)
+	holidayToday := s.holidays[t.Format(time.DateOnly)]
+	holidayYesterday := s.holidays[yesterday.Format(time.DateOnly)]
+
+	for _, w := range s.windows {
+		if w.from < w.to {
+			if w.appliesTo(t, holidayToday) && minute >= w.from && minute < w.to {
+				return true
+			}
+			continue
+		}
+		// spans midnight: the evening belongs to today, the morning to yesterday
+		if w.appliesTo(t, holidayToday) && minute >= w.from {
+			return true
+		}
+		if w.appliesTo(yesterday, holidayYesterday) && minute < w.to {
+			return true
+		}
+	}
+	return false
+}
+
+// nextOpen returns the next time from t on at which the schedule is open, or
+// false if there is none within a week.
+func (s *schedule) nextOpen(t time.Time) (time.Time, bool) {
+	t = t.Truncate(time.Minute)
+	for i := 0; i <= 8*24*60; i++ {
+		if s.isOpen(t) {
+			return t, true
+		}
+		t = t.Add(time.Minute)
+	}
+	return time.Time{}, false
+}
+
+// apply changes d for alarms outside of the configured hours. msg is nil for
+// an alarm restored on startup.
+func (s *schedule) apply(d decision, msg *messages.Alarm, now time.Time) decision {
+	if d.ignore || s.isOpen(now) {
+		return d
+	}
+	if msg != nil && msg.Priority && s.priorityOverride {
+		return d
+	}
+
+	d.quiet = true
+	switch s.outside {
+	case outsideSuppress:
+		d.ignore = true
+	case outsideDelay:
+		if t, ok := s.nextOpen(now); ok {
+			d.delayUntil = t
+		} else {
+			d.ignore = true
+		}
+	case outsideRestrict:
+		d.switchOn = s.restrictOn
+		d.actions = "restricted"
+	}
+	return d
+}
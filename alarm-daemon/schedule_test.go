@@ -0,0 +1,128 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	messages "github.com/CaptainStandby/divera-monitor/proto"
+	"github.com/stretchr/testify/assert"
+)
+
+func testSchedule(t *testing.T, outside string) *schedule {
+	holidays := filepath.Join(t.TempDir(), "holidays")
+	assert.NoError(t, os.WriteFile(holidays, []byte("# Weihnachten\n2026-12-25\n2026-12-26 # 2. Feiertag\n"), 0644))
+
+	s, err := buildSchedule(&scheduleConfig{
+		Timezone: "Europe/Berlin",
+		Hours: []windowConfig{
+			{Days: []string{"mon", "tue", "wed", "thu", "fri"}, From: "06:00", To: "22:00"},
+			{Days: []string{"sat", "sun", "holiday"}, From: "09:00", To: "01:00"},
+		},
+		Holidays:        []string{"2026-10-03"},
+		HolidaysFile:    holidays,
+		Outside:         outside,
+		RestrictActions: &actionListConfig{Actions: []actionConfig{{Type: "command", Command: "notify.sh"}}},
+	}, time.Second)
+	assert.NoError(t, err)
+	return s
+}
+
+func TestScheduleIsOpen(t *testing.T) {
+	s := testSchedule(t, outsideSuppress)
+	berlin, _ := time.LoadLocation("Europe/Berlin")
+	at := func(value string) time.Time {
+		v, err := time.ParseInLocation("2006-01-02 15:04", value, berlin)
+		assert.NoError(t, err)
+		return v
+	}
+
+	tt := []struct {
+		time string
+		open bool
+	}{
+		{"2026-10-19 05:59", false}, // Monday
+		{"2026-10-19 06:00", true},
+		{"2026-10-19 21:59", true},
+		{"2026-10-19 22:00", false},
+		{"2026-10-17 08:59", false}, // Saturday
+		{"2026-10-17 23:30", true},
+		{"2026-10-18 00:30", true}, // Saturday's window spans midnight
+		{"2026-10-18 01:00", false},
+		{"2026-10-20 00:30", false}, // Monday's window does not
+		{"2026-10-02 23:30", false}, // Friday
+		{"2026-10-03 08:00", false}, // holiday on a Saturday
+		{"2026-10-03 12:00", true},
+		{"2026-12-25 07:00", false}, // holiday on a Friday
+		{"2026-12-26 00:30", true},  // the night after a holiday
+	}
+
+	for _, tc := range tt {
+		assert.Equal(t, tc.open, s.isOpen(at(tc.time)), tc.time)
+	}
+
+	next, ok := s.nextOpen(at("2026-12-24 22:30"))
+	assert.True(t, ok)
+	assert.Equal(t, at("2026-12-25 09:00"), next)
+
+	// across the switch to winter time
+	next, ok = s.nextOpen(at("2026-10-25 01:30"))
+	assert.True(t, ok)
+	assert.Equal(t, at("2026-10-25 09:00"), next)
+}
+
+func TestScheduleApply(t *testing.T) {
+	berlin, _ := time.LoadLocation("Europe/Berlin")
+	night := time.Date(2026, 10, 19, 23, 0, 0, 0, berlin)
+	info := &messages.Alarm{Title: "Dienstabend"}
+	priority := &messages.Alarm{Title: "B3 Wohnungsbrand", Priority: true}
+	d := decision{lingerTime: time.Minute, actions: "default"}
+
+	assert.Equal(t, "no rule: switch on with default actions, linger 1m0s", testSchedule(t, outsideSuppress).apply(d, info, night.Add(-2*time.Hour)).String())
+	assert.Equal(t, "no rule: suppressed outside of the schedule", testSchedule(t, outsideSuppress).apply(d, info, night).String())
+	assert.Equal(t, "no rule: switch on at 2026-10-20 06:00 with default actions, linger 1m0s", testSchedule(t, outsideDelay).apply(d, info, night).String())
+	assert.Equal(t, "no rule: switch on with restricted actions, linger 1m0s", testSchedule(t, outsideRestrict).apply(d, info, night).String())
+	assert.Equal(t, "no rule: switch on with default actions, linger 1m0s", testSchedule(t, outsideSuppress).apply(d, priority, night).String())
+}
+
+func TestScheduleInvalid(t *testing.T) {
+	_, err := buildSchedule(&scheduleConfig{Hours: []windowConfig{{Days: []string{"mon"}, From: "6", To: "22:00"}}}, time.Second)
+	assert.EqualError(t, err, `schedule.hours 1: from: "6" is not a time like 06:30`)
+
+	_, err = buildSchedule(&scheduleConfig{Hours: []windowConfig{{Days: []string{"monday"}, From: "06:00", To: "22:00"}}}, time.Second)
+	assert.EqualError(t, err, `schedule.hours 1: unknown day "monday"`)
+
+	_, err = buildSchedule(&scheduleConfig{Hours: []windowConfig{{Days: []string{"mon"}, From: "06:00", To: "22:00"}}, Outside: "restrict"}, time.Second)
+	assert.EqualError(t, err, "schedule.restrict_actions are required with outside: restrict")
+}
+
+func TestWatcherDelayedBySchedule(t *testing.T) {
+	// opens in three days
+	s, err := buildSchedule(&scheduleConfig{
+		Hours:   []windowConfig{{Days: []string{time.Now().AddDate(0, 0, 3).Weekday().String()[:3]}, From: "00:00", To: "24:00"}},
+		Outside: outsideDelay,
+	}, time.Second)
+	assert.NoError(t, err)
+
+	w := newTestWatcher()
+	settings := watcherSettings{
+		lingerTime: time.Hour,
+		switchOn:   w.action("on", nil),
+		switchOff:  w.action("off", nil),
+		schedule:   s,
+	}
+	w.start(t, settings)
+
+	w.alarm(&messages.Alarm{Id: 1}, time.Now())
+	select {
+	case s := <-w.switched:
+		t.Fatalf("switched %s outside of the schedule", s)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	// without the schedule the delayed alarm is shown right away
+	settings.schedule = nil
+	w.reload <- settings
+	assert.Equal(t, "on", <-w.switched)
+}
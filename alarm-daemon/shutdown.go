@@ -0,0 +1,81 @@
+package main
+
+import (
+	"context"
+	"io"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+)
+
+const DEFAULT_SHUTDOWN_TIMEOUT = time.Minute
+
+// Exit codes of the daemon after a shutdown. Startup errors exit with 1 as
+// well.
+const (
+	EXIT_OK      = 0
+	EXIT_FAILED  = 1 // switching off or closing failed
+	EXIT_TIMEOUT = 3 // running actions were killed after the timeout
+	EXIT_FORCED  = 4 // a second signal ended the shutdown
+)
+
+// waitForShutdown waits for SIGINT or SIGTERM and shuts the daemon down, see
+// shutdown. It returns the exit code.
+func waitForShutdown(stopReceiving, cancel context.CancelFunc, done <-chan error, timeout time.Duration, closers ...io.Closer) int {
+	signals := make(chan os.Signal, 2)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(signals)
+
+	sig := <-signals
+	log.Printf("Shutdown requested by %s\n", sig)
+	return shutdown(signals, stopReceiving, cancel, done, timeout, closers...)
+}
+
+// shutdown stops receiving alarms and waits until the watcher is done with
+// the alarms it already got and, if configured, has switched the display
+// off. Actions still running after timeout are killed by cancel. The
+// closers are only closed once the watcher is done, so the history gets
+// everything it did. Another signal returns at once.
+func shutdown(signals <-chan os.Signal, stopReceiving, cancel context.CancelFunc, done <-chan error, timeout time.Duration, closers ...io.Closer) int {
+	stopReceiving()
+	code := EXIT_OK
+
+	select {
+	case sig := <-signals:
+		log.Printf("Shutdown forced by %s\n", sig)
+		return EXIT_FORCED
+	case err := <-done:
+		if err != nil {
+			log.Printf("shutdown: %v\n", err)
+			code = EXIT_FAILED
+		}
+	case <-time.After(timeout):
+		log.Printf("shutdown: actions still running after %s, killing them\n", timeout)
+		code = EXIT_TIMEOUT
+		cancel()
+		// killed commands may take COMMAND_WAIT_DELAY to return
+		select {
+		case sig := <-signals:
+			log.Printf("Shutdown forced by %s\n", sig)
+			return EXIT_FORCED
+		case <-done:
+		case <-time.After(COMMAND_WAIT_DELAY + time.Second):
+			log.Println("shutdown: watcher did not stop")
+		}
+	}
+	cancel()
+
+	for _, c := range closers {
+		if err := c.Close(); err != nil {
+			log.Printf("shutdown: close: %v\n", err)
+			if code == EXIT_OK {
+				code = EXIT_FAILED
+			}
+		}
+	}
+
+	log.Printf("Shutdown complete, exit code %d\n", code)
+	return code
+}
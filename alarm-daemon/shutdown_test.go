@@ -0,0 +1,106 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"os"
+	"syscall"
+	"testing"
+	"time"
+
+	messages "github.com/CaptainStandby/divera-monitor/proto"
+	"github.com/stretchr/testify/assert"
+)
+
+type fakeCloser struct{ closed bool }
+
+func (c *fakeCloser) Close() error {
+	c.closed = true
+	return nil
+}
+
+func TestShutdown(t *testing.T) {
+	tt := []struct {
+		name    string
+		watcher func(ctx context.Context, signals chan<- os.Signal) error
+		code    int
+		closed  bool
+	}{
+		{
+			name:    "clean",
+			watcher: func(context.Context, chan<- os.Signal) error { return nil },
+			code:    EXIT_OK,
+			closed:  true,
+		},
+		{
+			name:    "switching off failed",
+			watcher: func(context.Context, chan<- os.Signal) error { return errors.New("exit code 1") },
+			code:    EXIT_FAILED,
+			closed:  true,
+		},
+		{
+			name: "timeout",
+			watcher: func(ctx context.Context, _ chan<- os.Signal) error {
+				<-ctx.Done()
+				return ctx.Err()
+			},
+			code:   EXIT_TIMEOUT,
+			closed: true,
+		},
+		{
+			name: "forced",
+			watcher: func(ctx context.Context, signals chan<- os.Signal) error {
+				signals <- syscall.SIGTERM
+				<-ctx.Done()
+				return ctx.Err()
+			},
+			code:   EXIT_FORCED,
+			closed: false,
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			receiveCtx, stopReceiving := context.WithCancel(ctx)
+
+			signals := make(chan os.Signal, 1)
+			done := make(chan error, 1)
+			go func() {
+				<-receiveCtx.Done()
+				done <- tc.watcher(ctx, signals)
+			}()
+
+			closer := &fakeCloser{}
+			code := shutdown(signals, stopReceiving, cancel, done, 50*time.Millisecond, closer)
+			assert.Equal(t, tc.code, code)
+			assert.Equal(t, tc.closed, closer.closed)
+		})
+	}
+}
+
+func TestWatcherShutdown(t *testing.T) {
+	for _, offOnShutdown := range []bool{false, true} {
+		w := newTestWatcher()
+		w.pipeline = make(chan delivery, 1)
+		w.alarm(&messages.Alarm{Id: 1}, time.Now())
+		close(w.pipeline)
+
+		// the alarm in the pipeline is handled before the watcher stops
+		w.start(t, watcherSettings{
+			lingerTime:    time.Hour,
+			switchOff:     w.action("off", errors.New("exit code 1")),
+			offOnShutdown: offOnShutdown,
+		})
+		err := <-w.done
+		assert.Equal(t, "on", <-w.switched)
+		if offOnShutdown {
+			assert.EqualError(t, err, "exit code 1")
+			assert.Equal(t, "off", <-w.switched)
+		} else {
+			assert.NoError(t, err)
+		}
+		assert.Empty(t, w.switched)
+	}
+}
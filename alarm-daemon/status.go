@@ -0,0 +1,394 @@
+package main
+
+import (
+	"encoding/json"
+	"html/template"
+	"log"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	messages "github.com/CaptainStandby/divera-monitor/proto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"google.golang.org/protobuf/encoding/protojson"
+)
+
+const DEFAULT_STATUS_ADDRESS = "localhost:8080"
+
+// daemonStatus collects what the daemon is doing so it can be shown by the
+// status server. The watcher and the alarm source report into it, the HTTP
+// handlers read snapshots of it.
+type daemonStatus struct {
+	mu sync.Mutex
+
+	lastUpdate time.Time
+	lingerTime time.Duration
+
+	lastAlarm         *messages.Alarm
+	lastAlarmReceived time.Time
+
+	actions map[string]actionResult
+
+	reconcile *reconcileStatus
+
+	source sourceHealth
+
+	subscribers map[chan struct{}]struct{}
+}
+
+type actionResult struct {
+	Started  time.Time       `json:"started"`
+	Duration string          `json:"duration"`
+	Error    string          `json:"error,omitempty"`
+	Commands []commandResult `json:"commands,omitempty"`
+}
+
+// sourceHealth.State is connected, disconnected, retrying after a
+// transient error or failed after a permanent one.
+type sourceHealth struct {
+	Type          string     `json:"type"`
+	State         string     `json:"state"`
+	RetryAt       *time.Time `json:"retry_at,omitempty"`
+	Connected     bool       `json:"connected"`
+	Since         *time.Time `json:"since,omitempty"`
+	LastMessage   *time.Time `json:"last_message,omitempty"`
+	LastError     string     `json:"last_error,omitempty"`
+	LastErrorTime *time.Time `json:"last_error_time,omitempty"`
+}
+
+func newDaemonStatus(source string) *daemonStatus {
+	return &daemonStatus{
+		actions:     map[string]actionResult{},
+		source:      sourceHealth{Type: source, State: "disconnected"},
+		subscribers: map[chan struct{}]struct{}{},
+	}
+}
+
+// subscribe returns a channel that receives a value whenever the alarm or the
+// timer changes. Changes are coalesced, a slow reader only sees the latest.
+func (s *daemonStatus) subscribe() (<-chan struct{}, func()) {
+	ch := make(chan struct{}, 1)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.subscribers[ch] = struct{}{}
+	return ch, func() {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		delete(s.subscribers, ch)
+	}
+}
+
+// notify must be called with mu held.
+func (s *daemonStatus) notify() {
+	for ch := range s.subscribers {
+		select {
+		case ch <- struct{}{}:
+		default:
+		}
+	}
+}
+
+func (s *daemonStatus) setTimer(timer *alarmTimer) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.lastUpdate = timer.lastUpdate
+	s.lingerTime = timer.lingerTime
+	s.notify()
+}
+
+func (s *daemonStatus) alarmReceived(msg *messages.Alarm, received time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.lastAlarm = msg
+	s.lastAlarmReceived = received
+	s.notify()
+}
+
+// alarm returns the last received alarm, or nil.
+func (s *daemonStatus) alarm() *messages.Alarm {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.lastAlarm
+}
+
+// actionFinished keeps the outcome of the on, off, verify or alert actions
+// with the results of the commands they ran.
+func (s *daemonStatus) actionFinished(name string, started time.Time, err error, commands []commandResult) {
+	result := actionResult{
+		Started:  started,
+		Duration: time.Since(started).Round(time.Millisecond).String(),
+		Commands: commands,
+	}
+	if err != nil {
+		result.Error = err.Error()
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.actions[name] = result
+}
+
+func (s *daemonStatus) reconciled(r reconcileStatus) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.reconcile = &r
+}
+
+func (s *daemonStatus) connected() {
+	now := time.Now()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.source.State = "connected"
+	s.source.RetryAt = nil
+	s.source.Connected = true
+	s.source.Since = &now
+}
+
+func (s *daemonStatus) disconnected(err error) {
+	now := time.Now()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.source.State = "disconnected"
+	s.source.Connected = false
+	s.source.Since = &now
+	if err != nil {
+		s.source.LastError = err.Error()
+		s.source.LastErrorTime = &now
+	}
+}
+
+// retrying is reported by the alarm source while it waits to connect again.
+func (s *daemonStatus) retrying(at time.Time, permanent bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.source.State = "retrying"
+	if permanent {
+		s.source.State = "failed"
+	}
+	s.source.RetryAt = &at
+}
+
+func (s *daemonStatus) messageReceived() {
+	now := time.Now()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.source.LastMessage = &now
+}
+
+type timerSnapshot struct {
+	Active              bool      `json:"active"`
+	LastUpdate          time.Time `json:"last_update"`
+	StandbyTime         time.Time `json:"standby_time"`
+	LingerTime          string    `json:"linger_time"`
+	SecondsUntilStandby int64     `json:"seconds_until_standby"`
+}
+
+type alarmSnapshot struct {
+	Received time.Time       `json:"received"`
+	Alarm    json.RawMessage `json:"alarm"`
+}
+
+type statusSnapshot struct {
+	Now       time.Time               `json:"now"`
+	Timer     timerSnapshot           `json:"timer"`
+	LastAlarm *alarmSnapshot          `json:"last_alarm,omitempty"`
+	Actions   map[string]actionResult `json:"actions"`
+	Reconcile *reconcileStatus        `json:"reconcile,omitempty"`
+	Source    sourceHealth            `json:"source"`
+}
+
+func (s *daemonStatus) timer(now time.Time) timerSnapshot {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.timerLocked(now)
+}
+
+func (s *daemonStatus) timerLocked(now time.Time) timerSnapshot {
+	timer := alarmTimer{lastUpdate: s.lastUpdate, lingerTime: s.lingerTime}
+	standby := timer.standbyTime()
+	snap := timerSnapshot{
+		Active:      now.Before(standby),
+		LastUpdate:  s.lastUpdate,
+		StandbyTime: standby,
+		LingerTime:  s.lingerTime.String(),
+	}
+	if snap.Active {
+		snap.SecondsUntilStandby = int64(standby.Sub(now).Seconds())
+	}
+	return snap
+}
+
+func (s *daemonStatus) snapshot(now time.Time) statusSnapshot {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	snap := statusSnapshot{
+		Now:     now,
+		Timer:   s.timerLocked(now),
+		Actions: make(map[string]actionResult, len(s.actions)),
+		Source:  s.source,
+	}
+	if s.reconcile != nil {
+		r := *s.reconcile
+		snap.Reconcile = &r
+	}
+	for name, result := range s.actions {
+		snap.Actions[name] = result
+	}
+	if s.lastAlarm != nil {
+		data, err := protojson.Marshal(s.lastAlarm)
+		if err != nil {
+			log.Printf("status: protojson.Marshal err: %v\n", err)
+		} else {
+			snap.LastAlarm = &alarmSnapshot{Received: s.lastAlarmReceived, Alarm: data}
+		}
+	}
+	return snap
+}
+
+// newStatusHandler serves the status as JSON on /api/status and as a small
+// HTML page on /. The kiosk display is served on /kiosk and gets its updates
+// from /api/events. Prometheus metrics are on /metrics. Overrides are
+// accepted on /api/override unless overrides is nil.
+func newStatusHandler(status *daemonStatus, overrides chan<- overrideRequest, overrideToken string) http.Handler {
+	mux := http.NewServeMux()
+
+	mux.HandleFunc("/api/status", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", "no-store")
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		if err := encoder.Encode(status.snapshot(time.Now())); err != nil {
+			log.Printf("status: encode err: %v\n", err)
+		}
+	})
+
+	mux.HandleFunc("/api/events", serveEvents(status))
+	mux.HandleFunc("/kiosk", serveKiosk)
+	mux.Handle("/metrics", promhttp.Handler())
+	if overrides != nil {
+		var handler http.Handler = newOverrideHandler(overrides)
+		if overrideToken != "" {
+			handler = requireToken(overrideToken, handler)
+		}
+		mux.Handle("/api/override", handler)
+	}
+
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Header().Set("Cache-Control", "no-store")
+		if err := statusPage.Execute(w, status.snapshot(time.Now())); err != nil {
+			log.Printf("status: template err: %v\n", err)
+		}
+	})
+
+	return mux
+}
+
+var statusPage = template.Must(template.New("status").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<meta http-equiv="refresh" content="10">
+<title>alarm-daemon</title>
+<style>
+body { font-family: sans-serif; margin: 2em; }
+th { text-align: left; padding-right: 2em; }
+.active { color: #c00; font-weight: bold; }
+.error { color: #c00; }
+</style>
+</head>
+<body>
+<h1>alarm-daemon</h1>
+
+<h2>Alarm</h2>
+<table>
+<tr><th>State</th><td>{{if .Timer.Active}}<span class="active">active</span>{{else}}standby{{end}}</td></tr>
+<tr><th>Last update</th><td>{{.Timer.LastUpdate.Format "2006-01-02 15:04:05 MST"}}</td></tr>
+<tr><th>Standby at</th><td>{{.Timer.StandbyTime.Format "2006-01-02 15:04:05 MST"}}{{if .Timer.Active}} (in {{.Timer.SecondsUntilStandby}}s){{end}}</td></tr>
+<tr><th>Linger time</th><td>{{.Timer.LingerTime}}</td></tr>
+</table>
+
+<h2>Last alarm</h2>
+{{with .LastAlarm}}
+<p>Received {{.Received.Format "2006-01-02 15:04:05 MST"}}</p>
+<pre>{{printf "%s" .Alarm}}</pre>
+{{else}}
+<p>No alarm received since start.</p>
+{{end}}
+
+<h2>Actions</h2>
+<table>
+{{range $name, $result := .Actions}}
+<tr><th>{{$name}}</th><td>{{$result.Started.Format "2006-01-02 15:04:05 MST"}}, {{$result.Duration}}</td><td>{{if $result.Error}}<span class="error">{{$result.Error}}</span>{{else}}ok{{end}}</td></tr>
+{{range $result.Commands}}
+<tr><td></td><td colspan="2"><details><summary>{{.Command}}: {{if .Error}}{{.Error}}{{else if .TimedOut}}timed out{{else if .Signal}}killed by {{.Signal}}{{else}}exit code {{.ExitCode}}{{end}} after {{.Duration}}</summary>
+<pre>{{if .Truncated}}({{.Truncated}} lines omitted)
+{{end}}{{range .Output}}[{{.Stream}}] {{.Text}}
+{{end}}</pre></details></td></tr>
+{{end}}
+{{else}}
+<tr><td>No actions run since start.</td></tr>
+{{end}}
+</table>
+
+{{with .Reconcile}}
+<h2>Display</h2>
+<table>
+<tr><th>Desired</th><td>{{.Desired}}</td></tr>
+<tr><th>Actual</th><td>{{.Actual}}{{with .MismatchSince}} <span class="error">(differs since {{.Format "15:04:05"}})</span>{{end}}</td></tr>
+<tr><th>Checked</th><td>{{.Checked.Format "2006-01-02 15:04:05 MST"}}</td></tr>
+{{with .Error}}<tr><th>Error</th><td class="error">{{.}}</td></tr>{{end}}
+</table>
+{{end}}
+
+<h2>Source</h2>
+<table>
+<tr><th>Type</th><td>{{.Source.Type}}</td></tr>
+<tr><th>Connected</th><td>{{if .Source.Connected}}yes{{else}}<span class="error">{{.Source.State}}</span>{{end}}{{with .Source.Since}} since {{.Format "2006-01-02 15:04:05 MST"}}{{end}}</td></tr>
+{{with .Source.RetryAt}}<tr><th>Next attempt</th><td>{{.Format "2006-01-02 15:04:05 MST"}}</td></tr>{{end}}
+{{with .Source.LastMessage}}<tr><th>Last message</th><td>{{.Format "2006-01-02 15:04:05 MST"}}</td></tr>{{end}}
+{{with .Source.LastError}}<tr><th>Last error</th><td class="error">{{.}}</td></tr>{{end}}
+</table>
+</body>
+</html>
+`))
+
+// startStatusServer only accepts overrides on a loopback address unless a
+// token is required for them.
+func startStatusServer(address string, status *daemonStatus, overrides chan<- overrideRequest, overrideToken string) *http.Server {
+	if overrides != nil && overrideToken == "" && !isLoopback(address) {
+		log.Printf("status: not accepting overrides on %s without override.token\n", address)
+		overrides = nil
+	}
+	server := &http.Server{
+		Addr:              address,
+		Handler:           newStatusHandler(status, overrides, overrideToken),
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	go func() {
+		log.Printf("status: listening on http://%s/\n", address)
+		if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			log.Printf("status: %v\n", err)
+		}
+	}()
+	return server
+}
+
+// isLoopback reports whether address only listens on the local host.
+func isLoopback(address string) bool {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return false
+	}
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	messages "github.com/CaptainStandby/divera-monitor/proto"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStatusSnapshot(t *testing.T) {
+	now := time.Now()
+	status := newDaemonStatus("stream")
+
+	snap := status.snapshot(now)
+	assert.False(t, snap.Timer.Active)
+	assert.Nil(t, snap.LastAlarm)
+	assert.False(t, snap.Source.Connected)
+
+	status.setTimer(&alarmTimer{lastUpdate: now.Add(-5 * time.Minute), lingerTime: 15 * time.Minute})
+	status.alarmReceived(&messages.Alarm{Id: 42, Title: "B3 Wohnungsbrand"}, time.Now())
+	status.actionFinished("on", now, errors.New("command on.sh: exit status 1"), nil)
+	status.connected()
+
+	snap = status.snapshot(now)
+	assert.True(t, snap.Timer.Active)
+	assert.Equal(t, now.Add(10*time.Minute), snap.Timer.StandbyTime)
+	assert.Equal(t, int64(600), snap.Timer.SecondsUntilStandby)
+	assert.Equal(t, "15m0s", snap.Timer.LingerTime)
+	assert.JSONEq(t, `{"id":"42","title":"B3 Wohnungsbrand"}`, string(snap.LastAlarm.Alarm))
+	assert.Equal(t, "command on.sh: exit status 1", snap.Actions["on"].Error)
+	assert.True(t, snap.Source.Connected)
+
+	status.disconnected(errors.New("stream closed by server"))
+	snap = status.snapshot(now.Add(time.Hour))
+	assert.False(t, snap.Timer.Active)
+	assert.Equal(t, int64(0), snap.Timer.SecondsUntilStandby)
+	assert.False(t, snap.Source.Connected)
+	assert.Equal(t, "stream closed by server", snap.Source.LastError)
+}
+
+func TestStatusHandler(t *testing.T) {
+	status := newDaemonStatus("pubsub")
+	status.setTimer(&alarmTimer{lastUpdate: time.Now(), lingerTime: 15 * time.Minute})
+	status.actionFinished("on", time.Now(), nil, []commandResult{
+		{Command: "on.sh", ExitCode: 0, Duration: "3s", Output: []outputLine{{Stream: "stderr", Text: "TV is on"}}},
+	})
+	handler := newStatusHandler(status, nil, "")
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/status", nil))
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "application/json", rec.Header().Get("Content-Type"))
+
+	var body map[string]any
+	assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &body))
+	assert.Equal(t, true, body["timer"].(map[string]any)["active"])
+	assert.Equal(t, "pubsub", body["source"].(map[string]any)["type"])
+	assert.Contains(t, body["actions"], "on")
+
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Contains(t, rec.Body.String(), `<span class="active">active</span>`)
+	assert.Contains(t, rec.Body.String(), "<summary>on.sh: exit code 0 after 3s</summary>")
+	assert.Contains(t, rec.Body.String(), "[stderr] TV is on")
+
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/missing", nil))
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+}
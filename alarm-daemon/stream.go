@@ -0,0 +1,179 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	messages "github.com/CaptainStandby/divera-monitor/proto"
+	"google.golang.org/protobuf/encoding/protojson"
+)
+
+const STREAM_RECONNECT_DELAY = 5 * time.Second
+
+// STREAM_IDLE_TIMEOUT is twice the interval of the heartbeats alarm-ingress
+// sends. A connection without any data for longer is considered dead, e.g.
+// after the network dropped it without closing it.
+const STREAM_IDLE_TIMEOUT = 60 * time.Second
+
+var errStreamIdle = fmt.Errorf("no data for %s", STREAM_IDLE_TIMEOUT)
+
+// streamClient receives alarms from a standalone alarm-ingress over
+// Server-Sent Events. It reconnects after errors and resumes from the last
+// event it has seen.
+type streamClient struct {
+	url         string
+	token       string
+	client      *http.Client
+	lastEventID string
+
+	// idleTimeout replaces STREAM_IDLE_TIMEOUT in tests.
+	idleTimeout time.Duration
+}
+
+// idleReader resets timer whenever data arrives.
+type idleReader struct {
+	r       io.Reader
+	timer   *time.Timer
+	timeout time.Duration
+}
+
+func (r *idleReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	if n > 0 {
+		r.timer.Reset(r.timeout)
+	}
+	return n, err
+}
+
+// receive reads events until the connection fails or ctx is done. The
+// request is cancelled when neither an event nor a heartbeat arrives within
+// the idle timeout.
+func (s *streamClient) receive(ctx context.Context, connected func(), act func(ctx context.Context, msg *messages.Alarm) error) error {
+	timeout := s.idleTimeout
+	if timeout == 0 {
+		timeout = STREAM_IDLE_TIMEOUT
+	}
+	reqCtx, cancel := context.WithCancelCause(ctx)
+	defer cancel(nil)
+	timer := time.AfterFunc(timeout, func() { cancel(errStreamIdle) })
+	defer timer.Stop()
+
+	req, err := http.NewRequestWithContext(reqCtx, http.MethodGet, s.url, nil)
+	if err != nil {
+		return fmt.Errorf("http.NewRequest: %w", err)
+	}
+	req.Header.Set("Accept", "text/event-stream")
+	req.Header.Set("Authorization", "Bearer "+s.token)
+	if s.lastEventID != "" {
+		req.Header.Set("Last-Event-ID", s.lastEventID)
+	}
+
+	res, err := s.client.Do(req)
+	if err != nil {
+		if errors.Is(context.Cause(reqCtx), errStreamIdle) {
+			err = errStreamIdle
+		}
+		return fmt.Errorf("GET %s: %w", s.url, err)
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("GET %s: %s", s.url, res.Status)
+	}
+	log.Printf("stream: connected to %s\n", s.url)
+	connected()
+
+	var id, event string
+	var data []string
+
+	scanner := bufio.NewScanner(&idleReader{r: res.Body, timer: timer, timeout: timeout})
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if line == "" {
+			// a blank line dispatches the event
+			if event == "alarm" && len(data) > 0 {
+				// waiting for the watcher is not idling
+				timer.Stop()
+				if err := s.dispatch(ctx, strings.Join(data, "\n"), act); err != nil {
+					return err
+				}
+				timer.Reset(timeout)
+			}
+			if id != "" {
+				s.lastEventID = id
+			}
+			id, event, data = "", "", nil
+			continue
+		}
+
+		field, value, _ := strings.Cut(line, ":")
+		value = strings.TrimPrefix(value, " ")
+		switch field {
+		case "id":
+			id = value
+		case "event":
+			event = value
+		case "data":
+			data = append(data, value)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		if errors.Is(context.Cause(reqCtx), errStreamIdle) {
+			err = errStreamIdle
+		}
+		return fmt.Errorf("stream read: %w", err)
+	}
+
+	return fmt.Errorf("stream closed by server")
+}
+
+func (s *streamClient) dispatch(ctx context.Context, data string, act func(ctx context.Context, msg *messages.Alarm) error) error {
+	messagesReceived.WithLabelValues("stream").Inc()
+	message := &messages.Alarm{}
+	if err := protojson.Unmarshal([]byte(data), message); err != nil {
+		// skip the broken event, resending it would not help
+		log.Printf("protojson.Unmarshal err: %v\n", err)
+		decodeFailures.WithLabelValues("stream").Inc()
+		return nil
+	}
+
+	return act(ctx, message)
+}
+
+func startStreaming(ctx context.Context, client *streamClient, status *daemonStatus, watcher func(pipeline <-chan delivery)) {
+	pipeline := make(chan delivery, 10)
+
+	go watcher(pipeline)
+
+	go func() {
+		defer close(pipeline)
+		log.Println("Start streaming messages")
+
+		for {
+			err := client.receive(ctx, status.connected, func(ctx context.Context, msg *messages.Alarm) error {
+				status.messageReceived()
+				return act(ctx, delivery{alarm: msg, received: time.Now()}, pipeline)
+			})
+			if ctx.Err() != nil {
+				return
+			}
+			status.disconnected(err)
+			status.retrying(time.Now().Add(STREAM_RECONNECT_DELAY), false)
+			log.Printf("stream: %v, reconnecting in %s\n", err, STREAM_RECONNECT_DELAY)
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(STREAM_RECONNECT_DELAY):
+			}
+		}
+	}()
+}
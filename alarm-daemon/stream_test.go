@@ -0,0 +1,43 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	messages "github.com/CaptainStandby/divera-monitor/proto"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStreamIdleTimeout(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		fmt.Fprint(w, "id: 1\nevent: alarm\ndata: {\"id\": 7}\n\n")
+		for i := 0; i < 5; i++ {
+			fmt.Fprint(w, ": heartbeat\n\n")
+			w.(http.Flusher).Flush()
+			time.Sleep(50 * time.Millisecond)
+		}
+		// a connection that is gone without being closed
+		<-r.Context().Done()
+	}))
+	defer server.Close()
+
+	client := &streamClient{url: server.URL, client: &http.Client{}, idleTimeout: 200 * time.Millisecond}
+	var received []int64
+	start := time.Now()
+	err := client.receive(context.Background(), func() {}, func(ctx context.Context, msg *messages.Alarm) error {
+		received = append(received, msg.Id)
+		return nil
+	})
+
+	// the heartbeats keep the connection alive, the silence after them not
+	assert.ErrorIs(t, err, errStreamIdle)
+	assert.Equal(t, []int64{7}, received)
+	assert.Equal(t, "1", client.lastEventID)
+	assert.Greater(t, time.Since(start), 250*time.Millisecond)
+	assert.Less(t, time.Since(start), 2*time.Second)
+}
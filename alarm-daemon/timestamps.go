@@ -0,0 +1,100 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	messages "github.com/CaptainStandby/divera-monitor/proto"
+)
+
+const DEFAULT_TIMESTAMP_TOLERANCE = 2 * time.Minute
+
+// delivery is an alarm as it arrived from the alarm source. published is when
+// Pub/Sub accepted the message, by the clock of Pub/Sub. It is zero for the
+// stream.
+type delivery struct {
+	alarm     *messages.Alarm
+	received  time.Time
+	published time.Time
+}
+
+// timestampsConfig guards against wrong alarm times and local clocks. An
+// alarm time more than Tolerance in the future is clamped or, with Future
+// set to reject, the alarm is dropped. LingerFrom received ignores the
+// alarm time and lingers from when the alarm was received.
+type timestampsConfig struct {
+	Tolerance  duration `yaml:"tolerance"`
+	Future     string   `yaml:"future"`
+	LingerFrom string   `yaml:"linger_from"`
+}
+
+type timestampPolicy struct {
+	tolerance    time.Duration
+	reject       bool
+	fromReceived bool
+}
+
+func buildTimestampPolicy(c timestampsConfig) (timestampPolicy, error) {
+	p := timestampPolicy{tolerance: time.Duration(c.Tolerance)}
+	if p.tolerance < 0 {
+		return p, errors.New("timestamps.tolerance must not be negative")
+	}
+	if p.tolerance == 0 {
+		p.tolerance = DEFAULT_TIMESTAMP_TOLERANCE
+	}
+
+	switch c.Future {
+	case "", "clamp":
+	case "reject":
+		p.reject = true
+	default:
+		return p, fmt.Errorf("timestamps.future must be clamp or reject, not %s", c.Future)
+	}
+
+	switch c.LingerFrom {
+	case "", "alarm":
+	case "received":
+		p.fromReceived = true
+	default:
+		return p, fmt.Errorf("timestamps.linger_from must be alarm or received, not %s", c.LingerFrom)
+	}
+	return p, nil
+}
+
+// lingerStart returns when the linger time of d starts, by the local clock.
+//
+// The alarm can not have been updated after it was published or received.
+// A message received before it was published means the local clock is
+// behind, the alarm time is moved by the same offset then. A message
+// received long after it was published may just have waited in the
+// subscription, so that is not taken as a clock that is ahead.
+func (p timestampPolicy) lingerStart(d delivery) (time.Time, error) {
+	if p.fromReceived || d.alarm.Updated == nil {
+		return d.received, nil
+	}
+
+	updated := toTime(d.alarm.Updated)
+	latest := d.received
+	if !d.published.IsZero() {
+		if behind := d.published.Sub(d.received); behind > p.tolerance {
+			log.Printf("timestamps: the local clock is %s behind Pub/Sub\n", behind.Round(time.Second))
+			timestampCorrections.WithLabelValues("clock_behind").Inc()
+			updated = updated.Add(-behind)
+		} else if d.published.Before(d.received) {
+			latest = d.published
+		}
+	}
+
+	if ahead := updated.Sub(latest); ahead > p.tolerance {
+		if p.reject {
+			timestampCorrections.WithLabelValues("rejected").Inc()
+			return time.Time{}, fmt.Errorf("alarm time %s is %s in the future", updated.Format(time.DateTime), ahead.Round(time.Second))
+		}
+		log.Printf("timestamps: alarm time %s is %s in the future, using %s\n", updated.Format(time.DateTime), ahead.Round(time.Second), latest.Format(time.DateTime))
+		timestampCorrections.WithLabelValues("clamped").Inc()
+		return latest, nil
+	}
+	return updated, nil
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	messages "github.com/CaptainStandby/divera-monitor/proto"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLingerStart(t *testing.T) {
+	received := time.Date(2023, 7, 14, 18, 30, 0, 0, time.Local)
+	at := func(d time.Duration) *messages.Alarm {
+		return &messages.Alarm{Id: 1, Updated: &messages.Alarm_Timestamp{Seconds: received.Add(d).Unix()}}
+	}
+	clamp := timestampPolicy{tolerance: 2 * time.Minute}
+	reject := timestampPolicy{tolerance: 2 * time.Minute, reject: true}
+
+	tt := []struct {
+		name     string
+		policy   timestampPolicy
+		delivery delivery
+		start    time.Time
+		err      string
+	}{
+		{
+			name:     "in the past",
+			policy:   clamp,
+			delivery: delivery{alarm: at(-10 * time.Minute), received: received},
+			start:    received.Add(-10 * time.Minute),
+		},
+		{
+			name:     "within the tolerance",
+			policy:   reject,
+			delivery: delivery{alarm: at(time.Minute), received: received},
+			start:    received.Add(time.Minute),
+		},
+		{
+			name:     "future clamped",
+			policy:   clamp,
+			delivery: delivery{alarm: at(time.Hour), received: received},
+			start:    received,
+		},
+		{
+			name:     "future rejected",
+			policy:   reject,
+			delivery: delivery{alarm: at(time.Hour), received: received},
+			err:      "alarm time 2023-07-14 19:30:00 is 1h0m0s in the future",
+		},
+		{
+			name:     "clamped to the publish time",
+			policy:   clamp,
+			delivery: delivery{alarm: at(0), received: received, published: received.Add(-10 * time.Minute)},
+			start:    received.Add(-10 * time.Minute),
+		},
+		{
+			name:     "local clock behind",
+			policy:   reject,
+			delivery: delivery{alarm: at(9 * time.Minute), received: received, published: received.Add(10 * time.Minute)},
+			start:    received.Add(-time.Minute),
+		},
+		{
+			name:     "linger from received",
+			policy:   timestampPolicy{tolerance: 2 * time.Minute, fromReceived: true},
+			delivery: delivery{alarm: at(-10 * time.Minute), received: received},
+			start:    received,
+		},
+		{
+			name:     "no alarm time",
+			policy:   clamp,
+			delivery: delivery{alarm: &messages.Alarm{Id: 1}, received: received},
+			start:    received,
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			start, err := tc.policy.lingerStart(tc.delivery)
+			if tc.err != "" {
+				assert.EqualError(t, err, tc.err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tc.start, start)
+		})
+	}
+}
+
+func TestBuildTimestampPolicy(t *testing.T) {
+	p, err := buildTimestampPolicy(timestampsConfig{})
+	assert.NoError(t, err)
+	assert.Equal(t, timestampPolicy{tolerance: DEFAULT_TIMESTAMP_TOLERANCE}, p)
+
+	p, err = buildTimestampPolicy(timestampsConfig{Tolerance: duration(time.Minute), Future: "reject", LingerFrom: "received"})
+	assert.NoError(t, err)
+	assert.Equal(t, timestampPolicy{tolerance: time.Minute, reject: true, fromReceived: true}, p)
+
+	_, err = buildTimestampPolicy(timestampsConfig{Future: "ignore"})
+	assert.ErrorContains(t, err, "must be clamp or reject")
+	_, err = buildTimestampPolicy(timestampsConfig{LingerFrom: "created"})
+	assert.ErrorContains(t, err, "must be alarm or received")
+	_, err = buildTimestampPolicy(timestampsConfig{Tolerance: duration(-time.Minute)})
+	assert.ErrorContains(t, err, "must not be negative")
+}
@@ -11,83 +11,133 @@ import (
 
 	"github.com/pkg/errors"
 
-	"cloud.google.com/go/pubsub"
 	messages "github.com/CaptainStandby/divera-monitor/proto"
-	"google.golang.org/protobuf/proto"
 )
 
 type jsonAlarm struct {
-	ID               int64  `json:"id"`
-	ForeignID        string `json:"foreign_id"`
-	Title            string `json:"title"`
-	Text             string `json:"text"`
-	Address          string `json:"address"`
-	Lat              string `json:"lat"`
-	Lng              string `json:"lng"`
-	Priority         int    `json:"priority"`
-	NotificationType int    `json:"notification_type"`
-	Created          int64  `json:"ts_create"`
-	Updated          int64  `json:"ts_update"`
+	ID                  int64      `json:"id"`
+	ForeignID           string     `json:"foreign_id"`
+	AuthorID            int64      `json:"author_id"`
+	Title               string     `json:"title"`
+	Text                string     `json:"text"`
+	Report              string     `json:"report"`
+	Address             string     `json:"address"`
+	Lat                 coordinate `json:"lat"`
+	Lng                 coordinate `json:"lng"`
+	Priority            int        `json:"priority"`
+	NotificationType    int        `json:"notification_type"`
+	Cluster             []int64    `json:"cluster"`
+	Vehicle             []int64    `json:"vehicle"`
+	Group               []int64    `json:"group"`
+	UserClusterRelation []int64    `json:"user_cluster_relation"`
+	Closed              bool       `json:"closed"`
+	Archived            bool       `json:"archive"`
+	Created             int64      `json:"ts_create"`
+	Updated             int64      `json:"ts_update"`
 }
 
 func convertToProto(alarm *jsonAlarm) *messages.Alarm {
-	lat, err := strconv.ParseFloat(alarm.Lat, 64)
+	lat, err := alarm.Lat.float()
 	if err != nil {
 		log.Printf("could not parse latitude: %s", err.Error())
 		lat = 0
 	}
-	lng, err := strconv.ParseFloat(alarm.Lng, 64)
+	lng, err := alarm.Lng.float()
 	if err != nil {
 		log.Printf("could not parse longitude: %s", err.Error())
 		lng = 0
 	}
 
 	return &messages.Alarm{
-		Id:        alarm.ID,
-		ForeignId: alarm.ForeignID,
-		Title:     alarm.Title,
-		Text:      alarm.Text,
-		Address:   alarm.Address,
-		Position:  &messages.Alarm_LatLng{Latitude: lat, Longitude: lng},
-		Priority:  alarm.Priority != 0,
-		Created:   &messages.Alarm_Timestamp{Seconds: alarm.Created},
-		Updated:   &messages.Alarm_Timestamp{Seconds: alarm.Updated},
+		Id:                  alarm.ID,
+		ForeignId:           alarm.ForeignID,
+		Title:               alarm.Title,
+		Text:                alarm.Text,
+		Address:             alarm.Address,
+		Position:            &messages.Alarm_LatLng{Latitude: lat, Longitude: lng},
+		Priority:            alarm.Priority != 0,
+		Created:             &messages.Alarm_Timestamp{Seconds: alarm.Created},
+		Updated:             &messages.Alarm_Timestamp{Seconds: alarm.Updated},
+		Cluster:             alarm.Cluster,
+		Vehicle:             alarm.Vehicle,
+		Group:               alarm.Group,
+		UserClusterRelation: alarm.UserClusterRelation,
+		NotificationType:    int32(alarm.NotificationType),
+		Closed:              alarm.Closed,
+		Archived:            alarm.Archived,
+		AuthorId:            alarm.AuthorID,
+		Report:              alarm.Report,
 	}
 }
 
-func pushAlarm(
-	ctx context.Context,
-	alarm *jsonAlarm,
-	publish func(context.Context, *pubsub.Message) *pubsub.PublishResult) error {
+// pushAlarm returns the ID the publisher assigned to the message.
+func pushAlarm(ctx context.Context, alarm *jsonAlarm, publisher Publisher) (string, error) {
+	return publisher.Publish(ctx, &Message{
+		Alarm: convertToProto(alarm),
+		Attributes: map[string]string{
+			IdempotencyKeyAttribute: idempotencyKey(alarm.ID, alarm.Updated),
+		},
+		// keeps all updates of one alarm in order
+		OrderingKey: strconv.FormatInt(alarm.ID, 10),
+	})
+}
 
-	data, err := proto.Marshal(convertToProto(alarm))
-	if err != nil {
-		return errors.Wrap(err, "proto.Marshal() failed")
+// pushDeletion tells the subscribers that the alarm is gone. The timestamps
+// are zero, so subscribers that do not know the deleted field do not take it
+// for a new alarm.
+func pushDeletion(ctx context.Context, id int64, publisher Publisher) (string, error) {
+	return publisher.Publish(ctx, &Message{
+		Alarm: &messages.Alarm{
+			Id:      id,
+			Deleted: true,
+			Created: &messages.Alarm_Timestamp{},
+			Updated: &messages.Alarm_Timestamp{},
+		},
+		Attributes: map[string]string{
+			IdempotencyKeyAttribute: idempotencyKey(id, 0),
+		},
+		OrderingKey: strconv.FormatInt(id, 10),
+	})
+}
+
+// pushOnce skips alarms that were already published within the window of
+// dedup. It returns errDuplicate for those.
+func pushOnce(ctx context.Context, alarm *jsonAlarm, publisher Publisher, dedup *Deduplicator) (string, error) {
+	if dedup == nil {
+		return pushAlarm(ctx, alarm, publisher)
 	}
 
-	res := publish(ctx, &pubsub.Message{
-		Data: data,
-	})
-	_, err = res.Get(ctx)
+	key := idempotencyKey(alarm.ID, alarm.Updated)
+	if !dedup.claim(key, time.Now()) {
+		return "", errDuplicate
+	}
+	id, err := pushAlarm(ctx, alarm, publisher)
 	if err != nil {
-		return errors.Wrap(err, "Publish() failed")
+		dedup.release(key)
+		return "", err
 	}
-
-	return nil
+	return id, nil
 }
 
-func handle(w http.ResponseWriter, r *http.Request, pushAlarm func(context.Context, *jsonAlarm) error) {
+// handle reports decode failures to metrics, which may be nil.
+func handle(w http.ResponseWriter, r *http.Request, metrics Recorder, pushAlarm func(context.Context, *jsonAlarm) (string, error)) {
 	if r.Method != http.MethodPost {
-		w.WriteHeader(http.StatusMethodNotAllowed)
+		writeProblem(w, http.StatusMethodNotAllowed, "alarms must be sent with POST")
 		return
 	}
 
 	decoder := json.NewDecoder(r.Body)
 	msg := &jsonAlarm{}
 	err := decoder.Decode(msg)
+	if tooLarge := (&http.MaxBytesError{}); errors.As(err, &tooLarge) {
+		writeProblem(w, http.StatusRequestEntityTooLarge, fmt.Sprintf("body must not be larger than %d bytes", tooLarge.Limit))
+		return
+	}
 	if err != nil {
-		log.Println(err.Error())
-		w.WriteHeader(http.StatusBadRequest)
+		if metrics != nil {
+			metrics.DecodeFailure()
+		}
+		writeProblem(w, http.StatusBadRequest, fmt.Sprintf("body is not a valid alarm: %s", err.Error()))
 		return
 	}
 
@@ -96,22 +146,102 @@ func handle(w http.ResponseWriter, r *http.Request, pushAlarm func(context.Conte
 		Message:  fmt.Sprintf("Received divera message: %+v ", msg),
 	})
 
+	if errs := validate(msg, time.Now()); len(errs) > 0 {
+		writeProblem(w, http.StatusUnprocessableEntity, "alarm is invalid", errs...)
+		return
+	}
+
+	key := idempotencyKey(msg.ID, msg.Updated)
+
 	ctx, cancel := context.WithTimeout(context.Background(), time.Second*15)
 	defer cancel()
-	err = pushAlarm(ctx, msg)
+	id, err := pushAlarm(ctx, msg)
+	if errors.Is(err, errDuplicate) {
+		log.Println(Entry{
+			Message: fmt.Sprintf("Skipped duplicate of alarm %d (ts_update %d)", msg.ID, msg.Updated),
+		})
+		w.Header().Set("X-Alarm-Duplicate", "true")
+		writeResult(w, result{Status: "duplicate", IdempotencyKey: key})
+		return
+	}
 	if err != nil {
 		log.Println(err.Error())
-		w.WriteHeader(http.StatusInternalServerError)
+		writeProblem(w, http.StatusInternalServerError, "alarm could not be published")
 		return
 	}
 
-	w.WriteHeader(http.StatusOK)
+	writeResult(w, result{Status: "published", MessageID: id, IdempotencyKey: key})
 }
 
-func BuildHandler(publish func(context.Context, *pubsub.Message) *pubsub.PublishResult) http.HandlerFunc {
-	return func(w http.ResponseWriter, r *http.Request) {
-		handle(w, r, func(ctx context.Context, alarm *jsonAlarm) error {
-			return pushAlarm(ctx, alarm, publish)
+// Option configures the handler returned by BuildHandler.
+type Option func(*handlerOptions)
+
+// DefaultMaxBodySize limits the size of webhook requests.
+const DefaultMaxBodySize = 64 * 1024
+
+type handlerOptions struct {
+	auth        Authenticator
+	dedup       *Deduplicator
+	metrics     Recorder
+	maxBodySize int64
+}
+
+// WithAuthenticator rejects all requests that are not accepted by auth.
+func WithAuthenticator(auth Authenticator) Option {
+	return func(o *handlerOptions) {
+		o.auth = auth
+	}
+}
+
+// WithDeduplicator skips alarms that were already published within the
+// window of dedup.
+func WithDeduplicator(dedup *Deduplicator) Option {
+	return func(o *handlerOptions) {
+		o.dedup = dedup
+	}
+}
+
+// WithMetrics records requests, decode failures and publishes with metrics.
+func WithMetrics(metrics Recorder) Option {
+	return func(o *handlerOptions) {
+		o.metrics = metrics
+	}
+}
+
+// WithMaxBodySize rejects requests with a larger body, instead of
+// DefaultMaxBodySize.
+func WithMaxBodySize(n int64) Option {
+	return func(o *handlerOptions) {
+		o.maxBodySize = n
+	}
+}
+
+func BuildHandler(publisher Publisher, opts ...Option) http.HandlerFunc {
+	options := &handlerOptions{maxBodySize: DefaultMaxBodySize}
+	for _, opt := range opts {
+		opt(options)
+	}
+
+	publisher = Instrument(publisher, options.metrics)
+
+	var h http.HandlerFunc = func(w http.ResponseWriter, r *http.Request) {
+		handle(w, r, options.metrics, func(ctx context.Context, alarm *jsonAlarm) (string, error) {
+			return pushOnce(ctx, alarm, publisher, options.dedup)
 		})
 	}
+
+	if options.auth != nil {
+		h = authenticate(options.auth, h)
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		r.Body = http.MaxBytesReader(w, r.Body, options.maxBodySize)
+		if options.metrics == nil {
+			h(w, r)
+			return
+		}
+		sw := &statusWriter{ResponseWriter: w}
+		h(sw, r)
+		options.metrics.Request(sw.status)
+	}
 }
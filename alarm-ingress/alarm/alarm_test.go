@@ -2,12 +2,24 @@ package alarm
 
 import (
 	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
 	"net/http"
 	"net/http/httptest"
+	"os"
+	"path/filepath"
 	"strings"
 	"testing"
+	"time"
 
+	messages "github.com/CaptainStandby/divera-monitor/proto"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"github.com/stretchr/testify/assert"
+	"google.golang.org/protobuf/proto"
 )
 
 func TestAlarmHandler(t *testing.T) {
@@ -55,17 +67,21 @@ func TestAlarmHandler(t *testing.T) {
 			}`,
 			statusCode: http.StatusOK,
 			expect: &jsonAlarm{
-				ID:               11253967,
-				ForeignID:        "",
-				Title:            "TEST TEST TEST",
-				Text:             "",
-				Address:          "Bockholz 2, Winnemark, Germany",
-				Lat:              "54.60561010",
-				Lng:              "9.93120260",
-				Priority:         0,
-				NotificationType: 4,
-				Created:          1689757211,
-				Updated:          1689757211,
+				ID:                  11253967,
+				ForeignID:           "",
+				Title:               "TEST TEST TEST",
+				Text:                "",
+				Address:             "Bockholz 2, Winnemark, Germany",
+				Lat:                 "54.60561010",
+				Lng:                 "9.93120260",
+				Priority:            0,
+				NotificationType:    4,
+				Cluster:             []int64{},
+				Vehicle:             []int64{},
+				Group:               []int64{},
+				UserClusterRelation: []int64{530527},
+				Created:             1689757211,
+				Updated:             1689757211,
 			},
 		},
 		{
@@ -90,17 +106,21 @@ func TestAlarmHandler(t *testing.T) {
 			}`,
 			statusCode: http.StatusOK,
 			expect: &jsonAlarm{
-				ID:               11254160,
-				ForeignID:        "",
-				Title:            "TEST TEST TEST",
-				Text:             "",
-				Address:          "",
-				Lat:              "",
-				Lng:              "",
-				Priority:         1,
-				NotificationType: 4,
-				Created:          1689758202,
-				Updated:          1689758202,
+				ID:                  11254160,
+				ForeignID:           "",
+				Title:               "TEST TEST TEST",
+				Text:                "",
+				Address:             "",
+				Lat:                 "",
+				Lng:                 "",
+				Priority:            1,
+				NotificationType:    4,
+				Cluster:             []int64{},
+				Vehicle:             []int64{},
+				Group:               []int64{},
+				UserClusterRelation: []int64{530527},
+				Created:             1689758202,
+				Updated:             1689758202,
 			},
 		},
 		{
@@ -125,19 +145,58 @@ func TestAlarmHandler(t *testing.T) {
 			}`,
 			statusCode: http.StatusOK,
 			expect: &jsonAlarm{
-				ID:               11253859,
-				ForeignID:        "",
-				Title:            "TEST TEST TEST",
-				Text:             "",
-				Address:          "Bockholz 2, Winnemark, Germany",
-				Lat:              "",
-				Lng:              "",
-				Priority:         0,
-				NotificationType: 4,
-				Created:          1689756639,
-				Updated:          1689756662,
+				ID:                  11253859,
+				ForeignID:           "",
+				Title:               "TEST TEST TEST",
+				Text:                "",
+				Address:             "Bockholz 2, Winnemark, Germany",
+				Lat:                 "",
+				Lng:                 "",
+				Priority:            0,
+				NotificationType:    4,
+				Cluster:             []int64{},
+				Vehicle:             []int64{},
+				Group:               []int64{},
+				UserClusterRelation: []int64{530527},
+				Created:             1689756639,
+				Updated:             1689756662,
 			},
 		},
+		{
+			name:   "Accept numeric coordinates",
+			method: http.MethodPost,
+			body: `{
+				"id": 11253967,
+				"title": "TEST TEST TEST",
+				"lat": 54.6056101,
+				"lng": 9.9312026,
+				"ts_create": 1689757211,
+				"ts_update": 1689757211
+			}`,
+			statusCode: http.StatusOK,
+			expect: &jsonAlarm{
+				ID:      11253967,
+				Title:   "TEST TEST TEST",
+				Lat:     "54.6056101",
+				Lng:     "9.9312026",
+				Created: 1689757211,
+				Updated: 1689757211,
+			},
+		},
+		{
+			name:       "reject invalid alarm",
+			method:     http.MethodPost,
+			body:       `{ "id": 0, "lat": "95", "lng": "east", "ts_create": 1689757211, "ts_update": 1689757000 }`,
+			statusCode: http.StatusUnprocessableEntity,
+			expect:     nil,
+		},
+		{
+			name:       "reject coordinates that are not finite",
+			method:     http.MethodPost,
+			body:       `{ "id": 1, "lat": "NaN", "lng": "-Inf", "ts_create": 1689757211, "ts_update": 1689757211 }`,
+			statusCode: http.StatusUnprocessableEntity,
+			expect:     nil,
+		},
 	}
 
 	for _, tc := range tt {
@@ -146,9 +205,9 @@ func TestAlarmHandler(t *testing.T) {
 			responseRecorder := httptest.NewRecorder()
 
 			var pushed *jsonAlarm
-			handle(responseRecorder, request, func(ctx context.Context, ja *jsonAlarm) error {
+			handle(responseRecorder, request, nil, func(ctx context.Context, ja *jsonAlarm) (string, error) {
 				pushed = ja
-				return nil
+				return "1", nil
 			})
 
 			assert.Equal(t, tc.statusCode, responseRecorder.Code)
@@ -156,3 +215,325 @@ func TestAlarmHandler(t *testing.T) {
 		})
 	}
 }
+
+func TestAuthenticate(t *testing.T) {
+	body := `{"id": 11253967, "title": "TEST TEST TEST", "ts_create": 1689757211, "ts_update": 1689757211}`
+
+	mac := hmac.New(sha256.New, []byte("hmac-key"))
+	mac.Write([]byte(body))
+	signature := hex.EncodeToString(mac.Sum(nil))
+
+	headerSecret := HeaderSecret{Header: "X-Divera-Secret", Secret: "s3cr3t"}
+	queryToken := QueryToken{Param: "accesskey", Token: "t0k3n"}
+	hmacSignature := HMACSignature{Header: "X-Divera-Signature", Key: []byte("hmac-key")}
+
+	tt := []struct {
+		name       string
+		auth       Authenticator
+		target     string
+		header     map[string]string
+		statusCode int
+	}{
+		{
+			name:       "header secret missing",
+			auth:       headerSecret,
+			target:     "/",
+			statusCode: http.StatusUnauthorized,
+		},
+		{
+			name:       "header secret wrong",
+			auth:       headerSecret,
+			target:     "/",
+			header:     map[string]string{"X-Divera-Secret": "wrong"},
+			statusCode: http.StatusUnauthorized,
+		},
+		{
+			name:       "header secret valid",
+			auth:       headerSecret,
+			target:     "/",
+			header:     map[string]string{"X-Divera-Secret": "s3cr3t"},
+			statusCode: http.StatusOK,
+		},
+		{
+			name:       "query token missing",
+			auth:       queryToken,
+			target:     "/",
+			statusCode: http.StatusUnauthorized,
+		},
+		{
+			name:       "query token wrong",
+			auth:       queryToken,
+			target:     "/?accesskey=wrong",
+			statusCode: http.StatusUnauthorized,
+		},
+		{
+			name:       "query token valid",
+			auth:       queryToken,
+			target:     "/?accesskey=t0k3n",
+			statusCode: http.StatusOK,
+		},
+		{
+			name:       "hmac signature missing",
+			auth:       hmacSignature,
+			target:     "/",
+			statusCode: http.StatusUnauthorized,
+		},
+		{
+			name:       "hmac signature over other body",
+			auth:       hmacSignature,
+			target:     "/",
+			header:     map[string]string{"X-Divera-Signature": hex.EncodeToString(make([]byte, sha256.Size))},
+			statusCode: http.StatusUnauthorized,
+		},
+		{
+			name:       "hmac signature valid",
+			auth:       hmacSignature,
+			target:     "/",
+			header:     map[string]string{"X-Divera-Signature": signature},
+			statusCode: http.StatusOK,
+		},
+		{
+			name:       "hmac signature valid with prefix",
+			auth:       hmacSignature,
+			target:     "/",
+			header:     map[string]string{"X-Divera-Signature": "sha256=" + signature},
+			statusCode: http.StatusOK,
+		},
+		{
+			name:       "all of requires every method",
+			auth:       AllOf{queryToken, hmacSignature},
+			target:     "/?accesskey=t0k3n",
+			statusCode: http.StatusUnauthorized,
+		},
+		{
+			name:       "all of valid",
+			auth:       AllOf{queryToken, hmacSignature},
+			target:     "/?accesskey=t0k3n",
+			header:     map[string]string{"X-Divera-Signature": signature},
+			statusCode: http.StatusOK,
+		},
+		{
+			name:       "empty all of rejects",
+			auth:       AllOf{},
+			target:     "/?accesskey=t0k3n",
+			statusCode: http.StatusUnauthorized,
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			request := httptest.NewRequest(http.MethodPost, tc.target, strings.NewReader(body))
+			for k, v := range tc.header {
+				request.Header.Set(k, v)
+			}
+			responseRecorder := httptest.NewRecorder()
+
+			var pushed *jsonAlarm
+			authenticate(tc.auth, func(w http.ResponseWriter, r *http.Request) {
+				handle(w, r, nil, func(ctx context.Context, ja *jsonAlarm) (string, error) {
+					pushed = ja
+					return "1", nil
+				})
+			})(responseRecorder, request)
+
+			assert.Equal(t, tc.statusCode, responseRecorder.Code)
+			if tc.statusCode == http.StatusOK {
+				assert.NotNil(t, pushed)
+			} else {
+				assert.Nil(t, pushed)
+			}
+		})
+	}
+}
+
+func TestConvertToProto(t *testing.T) {
+	body := `{
+		"id": 11253967,
+		"foreign_id": "E-42",
+		"author_id": 4711,
+		"title": "B2 Wohnungsbrand",
+		"text": "Rauch aus Fenster",
+		"report": "Einsatz beendet",
+		"address": "Bockholz 2, Winnemark, Germany",
+		"lat": "54.60561010",
+		"lng": "9.93120260",
+		"priority": 1,
+		"notification_type": 3,
+		"cluster": [101],
+		"vehicle": [201, 202],
+		"group": [301],
+		"user_cluster_relation": [530527],
+		"closed": true,
+		"archive": false,
+		"ts_create": 1689757211,
+		"ts_update": 1689757299
+	}`
+
+	alarm := &jsonAlarm{}
+	assert.NoError(t, json.Unmarshal([]byte(body), alarm))
+
+	expect := &messages.Alarm{
+		Id:                  11253967,
+		ForeignId:           "E-42",
+		Title:               "B2 Wohnungsbrand",
+		Text:                "Rauch aus Fenster",
+		Address:             "Bockholz 2, Winnemark, Germany",
+		Position:            &messages.Alarm_LatLng{Latitude: 54.6056101, Longitude: 9.9312026},
+		Priority:            true,
+		Created:             &messages.Alarm_Timestamp{Seconds: 1689757211},
+		Updated:             &messages.Alarm_Timestamp{Seconds: 1689757299},
+		Cluster:             []int64{101},
+		Vehicle:             []int64{201, 202},
+		Group:               []int64{301},
+		UserClusterRelation: []int64{530527},
+		NotificationType:    3,
+		Closed:              true,
+		AuthorId:            4711,
+		Report:              "Einsatz beendet",
+	}
+
+	actual := convertToProto(alarm)
+	assert.True(t, proto.Equal(expect, actual), "expected %v, got %v", expect, actual)
+}
+
+func TestDeduplication(t *testing.T) {
+	body := `{"id": 11253967, "title": "TEST TEST TEST", "ts_create": 1689757211, "ts_update": 1689757211}`
+	update := `{"id": 11253967, "title": "TEST TEST TEST", "ts_create": 1689757211, "ts_update": 1689757299}`
+
+	path := filepath.Join(t.TempDir(), "dedup.json")
+	dedup, err := NewDeduplicator(time.Hour, path)
+	assert.NoError(t, err)
+
+	publisher := &fakePublisher{}
+	handler := BuildHandler(publisher, WithDeduplicator(dedup))
+
+	post := func(handler http.HandlerFunc, body string) *httptest.ResponseRecorder {
+		responseRecorder := httptest.NewRecorder()
+		handler(responseRecorder, httptest.NewRequest(http.MethodPost, "/", strings.NewReader(body)))
+		return responseRecorder
+	}
+
+	res := post(handler, body)
+	assert.Equal(t, http.StatusOK, res.Code)
+	assert.Empty(t, res.Header().Get("X-Alarm-Duplicate"))
+
+	res = post(handler, body)
+	assert.Equal(t, http.StatusOK, res.Code)
+	assert.Equal(t, "true", res.Header().Get("X-Alarm-Duplicate"))
+
+	res = post(handler, update)
+	assert.Equal(t, http.StatusOK, res.Code)
+	assert.Empty(t, res.Header().Get("X-Alarm-Duplicate"))
+
+	if assert.Len(t, publisher.published, 2) {
+		msg := publisher.published[1]
+		assert.Equal(t, "11253967-1689757299", msg.Attributes[IdempotencyKeyAttribute])
+		assert.Equal(t, "11253967", msg.OrderingKey)
+	}
+
+	// keys survive a restart
+	dedup, err = NewDeduplicator(time.Hour, path)
+	assert.NoError(t, err)
+	res = post(BuildHandler(publisher, WithDeduplicator(dedup)), update)
+	assert.Equal(t, "true", res.Header().Get("X-Alarm-Duplicate"))
+
+	// a failed publish does not block the retry
+	failing := &fakePublisher{err: errors.New("unavailable")}
+	handler = BuildHandler(failing, WithDeduplicator(dedup))
+	retry := `{"id": 11254160, "title": "TEST TEST TEST", "ts_create": 1689758202, "ts_update": 1689758202}`
+	res = post(handler, retry)
+	assert.Equal(t, http.StatusInternalServerError, res.Code)
+	failing.err = nil
+	res = post(handler, retry)
+	assert.Equal(t, http.StatusOK, res.Code)
+	assert.Empty(t, res.Header().Get("X-Alarm-Duplicate"))
+
+	// no temporary files are left behind
+	entries, err := os.ReadDir(filepath.Dir(path))
+	assert.NoError(t, err)
+	assert.Len(t, entries, 1)
+
+	// a file torn by a crash does not stop the ingress from starting
+	assert.NoError(t, os.WriteFile(path, []byte(`{"11253967-1689757299": "2023-07-`), 0644))
+	dedup, err = NewDeduplicator(time.Hour, path)
+	assert.NoError(t, err)
+	res = post(BuildHandler(publisher, WithDeduplicator(dedup)), update)
+	assert.Equal(t, http.StatusOK, res.Code)
+	assert.Empty(t, res.Header().Get("X-Alarm-Duplicate"))
+}
+
+func TestProblemResponse(t *testing.T) {
+	handler := BuildHandler(&fakePublisher{}, WithMaxBodySize(128))
+
+	decode := func(res *httptest.ResponseRecorder) problem {
+		assert.Equal(t, "application/problem+json", res.Header().Get("Content-Type"))
+		p := problem{}
+		assert.NoError(t, json.NewDecoder(res.Body).Decode(&p))
+		assert.Equal(t, res.Code, p.Status)
+		return p
+	}
+
+	res := httptest.NewRecorder()
+	handler(res, httptest.NewRequest(http.MethodPost, "/",
+		strings.NewReader(`{ "id": 0, "lat": "95", "lng": "east", "ts_create": 1689757211, "ts_update": 1689757000 }`)))
+	assert.Equal(t, http.StatusUnprocessableEntity, res.Code)
+	assert.ElementsMatch(t, []fieldError{
+		{Field: "id", Message: "is required"},
+		{Field: "ts_update", Message: "is before ts_create"},
+		{Field: "lat", Message: "95 is not within ±90"},
+		{Field: "lng", Message: `"east" is not a number`},
+	}, decode(res).Errors)
+
+	res = httptest.NewRecorder()
+	handler(res, httptest.NewRequest(http.MethodPost, "/",
+		strings.NewReader(`{ "id": 1, "lat": "nan", "lng": "Infinity", "ts_create": 1689757211, "ts_update": 1689757211 }`)))
+	assert.Equal(t, http.StatusUnprocessableEntity, res.Code)
+	assert.ElementsMatch(t, []fieldError{
+		{Field: "lat", Message: `"nan" is not a number`},
+		{Field: "lng", Message: `"Infinity" is not a number`},
+	}, decode(res).Errors)
+
+	res = httptest.NewRecorder()
+	handler(res, httptest.NewRequest(http.MethodPost, "/",
+		strings.NewReader(`{ "id": 1, "title": "`+strings.Repeat("x", 200)+`" }`)))
+	assert.Equal(t, http.StatusRequestEntityTooLarge, res.Code)
+	decode(res)
+
+	res = httptest.NewRecorder()
+	handler(res, httptest.NewRequest(http.MethodPost, "/",
+		strings.NewReader(`{ "id": 1, "ts_create": 1689757211, "ts_update": 1689757211 }`)))
+	assert.Equal(t, http.StatusOK, res.Code)
+	assert.Equal(t, "application/json", res.Header().Get("Content-Type"))
+	r := result{}
+	assert.NoError(t, json.NewDecoder(res.Body).Decode(&r))
+	assert.Equal(t, result{Status: "published", MessageID: "1", IdempotencyKey: "1-1689757211"}, r)
+}
+
+func TestMetrics(t *testing.T) {
+	registry := prometheus.NewRegistry()
+	metrics := NewPrometheusRecorder(registry)
+	publisher := &fakePublisher{}
+	handler := BuildHandler(publisher, WithMetrics(metrics))
+
+	post := func(body string) {
+		handler(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/", strings.NewReader(body)))
+	}
+
+	post(`{"id": 11253967, "title": "TEST TEST TEST", "ts_create": 1689757211, "ts_update": 1689757211}`)
+	post(`{"id": 11253967,`)
+	post(`{"id": 0}`)
+	publisher.err = errors.New("unavailable")
+	post(`{"id": 11253968, "title": "TEST TEST TEST", "ts_create": 1689757211, "ts_update": 1689757211}`)
+
+	res := httptest.NewRecorder()
+	promhttp.HandlerFor(registry, promhttp.HandlerOpts{}).ServeHTTP(res, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	scraped := res.Body.String()
+
+	assert.Contains(t, scraped, `alarm_ingress_requests_total{code="200"} 1`)
+	assert.Contains(t, scraped, `alarm_ingress_requests_total{code="400"} 1`)
+	assert.Contains(t, scraped, `alarm_ingress_requests_total{code="422"} 1`)
+	assert.Contains(t, scraped, `alarm_ingress_requests_total{code="500"} 1`)
+	assert.Contains(t, scraped, "alarm_ingress_decode_failures_total 1\n")
+	assert.Contains(t, scraped, "alarm_ingress_publish_duration_seconds_count 2\n")
+	assert.Contains(t, scraped, "alarm_ingress_publish_errors_total 1\n")
+}
@@ -0,0 +1,127 @@
+package alarm
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// ErrUnauthenticated is returned by an Authenticator if the request does not
+// carry valid credentials.
+var ErrUnauthenticated = errors.New("unauthenticated")
+
+// Authenticator decides whether an incoming webhook request may publish an alarm.
+// The body is passed separately because it has already been read from the request.
+type Authenticator interface {
+	Authenticate(r *http.Request, body []byte) error
+}
+
+// HeaderSecret requires a shared secret in a request header.
+type HeaderSecret struct {
+	Header string
+	Secret string
+}
+
+func (h HeaderSecret) Authenticate(r *http.Request, _ []byte) error {
+	if !secretEqual(r.Header.Get(h.Header), h.Secret) {
+		return errors.Wrapf(ErrUnauthenticated, "header %s does not match", h.Header)
+	}
+	return nil
+}
+
+// QueryToken requires an access token in a query parameter, the way Divera
+// passes its own access keys.
+type QueryToken struct {
+	Param string
+	Token string
+}
+
+func (q QueryToken) Authenticate(r *http.Request, _ []byte) error {
+	if !secretEqual(r.URL.Query().Get(q.Param), q.Token) {
+		return errors.Wrapf(ErrUnauthenticated, "query parameter %s does not match", q.Param)
+	}
+	return nil
+}
+
+// HMACSignature requires a hex encoded HMAC-SHA256 of the request body in a
+// request header. An optional "sha256=" prefix is accepted.
+type HMACSignature struct {
+	Header string
+	Key    []byte
+}
+
+func (s HMACSignature) Authenticate(r *http.Request, body []byte) error {
+	value := strings.TrimPrefix(r.Header.Get(s.Header), "sha256=")
+	signature, err := hex.DecodeString(value)
+	if err != nil || len(signature) == 0 {
+		return errors.Wrapf(ErrUnauthenticated, "header %s does not contain a signature", s.Header)
+	}
+
+	mac := hmac.New(sha256.New, s.Key)
+	mac.Write(body)
+	if !hmac.Equal(signature, mac.Sum(nil)) {
+		return errors.Wrapf(ErrUnauthenticated, "signature in header %s does not match", s.Header)
+	}
+	return nil
+}
+
+// AllOf requires every contained Authenticator to succeed. An empty AllOf
+// rejects every request.
+type AllOf []Authenticator
+
+func (a AllOf) Authenticate(r *http.Request, body []byte) error {
+	if len(a) == 0 {
+		return errors.Wrap(ErrUnauthenticated, "no authenticator configured")
+	}
+	for _, auth := range a {
+		if err := auth.Authenticate(r, body); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func secretEqual(given, expected string) bool {
+	if given == "" || expected == "" {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(given), []byte(expected)) == 1
+}
+
+// authenticate wraps next and rejects requests that do not pass auth with 401.
+// The body is buffered so that next can read it again.
+func authenticate(auth Authenticator, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(r.Body)
+		if tooLarge := (&http.MaxBytesError{}); errors.As(err, &tooLarge) {
+			writeProblem(w, http.StatusRequestEntityTooLarge, fmt.Sprintf("body must not be larger than %d bytes", tooLarge.Limit))
+			return
+		}
+		if err != nil {
+			writeProblem(w, http.StatusBadRequest, fmt.Sprintf("body could not be read: %s", err.Error()))
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+
+		if err := auth.Authenticate(r, body); err != nil {
+			log.Println(Entry{
+				Severity: "WARNING",
+				Message:  fmt.Sprintf("Rejected request from %s: %s", r.RemoteAddr, err.Error()),
+			})
+			// do not tell which check failed
+			writeProblem(w, http.StatusUnauthorized, "request is not authenticated")
+			return
+		}
+
+		next(w, r)
+	}
+}
@@ -0,0 +1,136 @@
+package alarm
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// IdempotencyKeyAttribute is the message attribute carrying the idempotency
+// key, so that subscribers can deduplicate as well.
+const IdempotencyKeyAttribute = "idempotency_key"
+
+var errDuplicate = errors.New("duplicate alarm")
+
+// idempotencyKey identifies one version of an alarm. Divera resends the
+// webhook for every update, but a retry of the same update has the same key.
+func idempotencyKey(id, updated int64) string {
+	return fmt.Sprintf("%d-%d", id, updated)
+}
+
+// Deduplicator remembers the idempotency keys published within a window.
+// If a path is set, the keys are persisted there and survive restarts.
+type Deduplicator struct {
+	window time.Duration
+	path   string
+
+	mu   sync.Mutex
+	seen map[string]time.Time
+}
+
+// NewDeduplicator creates a deduplicator and loads the keys persisted at
+// path, if path is not empty. A file that can not be decoded is logged and
+// the deduplicator starts without keys, a retry may be published again then.
+func NewDeduplicator(window time.Duration, path string) (*Deduplicator, error) {
+	d := &Deduplicator{
+		window: window,
+		path:   path,
+		seen:   make(map[string]time.Time),
+	}
+
+	if path == "" {
+		return d, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return d, nil
+	}
+	if err != nil {
+		return nil, errors.Wrapf(err, "could not read %s", path)
+	}
+	if err := json.Unmarshal(data, &d.seen); err != nil {
+		log.Println(Entry{
+			Severity: "WARNING",
+			Message:  fmt.Sprintf("could not decode idempotency keys from %s, starting without them: %s", path, err.Error()),
+		})
+		d.seen = make(map[string]time.Time)
+	}
+
+	return d, nil
+}
+
+// claim records key and reports whether it was not seen within the window
+// before. A claimed key must be released if publishing fails, so that a retry
+// is not suppressed.
+func (d *Deduplicator) claim(key string, now time.Time) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	for k, t := range d.seen {
+		if now.Sub(t) > d.window {
+			delete(d.seen, k)
+		}
+	}
+
+	if _, ok := d.seen[key]; ok {
+		return false
+	}
+	d.seen[key] = now
+	d.persist()
+	return true
+}
+
+func (d *Deduplicator) release(key string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	delete(d.seen, key)
+	d.persist()
+}
+
+func (d *Deduplicator) persist() {
+	if d.path == "" {
+		return
+	}
+
+	data, err := json.Marshal(d.seen)
+	if err == nil {
+		err = writeFileAtomic(d.path, data)
+	}
+	if err != nil {
+		log.Println(Entry{
+			Severity: "WARNING",
+			Message:  fmt.Sprintf("could not persist idempotency keys to %s: %s", d.path, err.Error()),
+		})
+	}
+}
+
+// writeFileAtomic writes data to a temporary file next to path and renames it
+// into place, so a crash never leaves a torn file behind.
+func writeFileAtomic(path string, data []byte) error {
+	f, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Chmod(0644); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), path)
+}
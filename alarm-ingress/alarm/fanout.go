@@ -0,0 +1,78 @@
+package alarm
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// HTTPFanout posts every alarm to all configured URLs. Publishing fails if
+// any of the targets does not accept the alarm. Message attributes are sent as
+// X-Alarm-<name> headers.
+type HTTPFanout struct {
+	Client   *http.Client
+	URLs     []string
+	Encoding Encoding
+}
+
+func (p *HTTPFanout) Publish(ctx context.Context, msg *Message) (string, error) {
+	data, err := p.Encoding.marshal(msg.Alarm)
+	if err != nil {
+		return "", err
+	}
+
+	client := p.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	var wg sync.WaitGroup
+	errs := make([]error, len(p.URLs))
+	for i, url := range p.URLs {
+		wg.Add(1)
+		go func(i int, url string) {
+			defer wg.Done()
+			errs[i] = p.post(ctx, client, url, data, msg.Attributes)
+		}(i, url)
+	}
+	wg.Wait()
+
+	var failed []string
+	for _, err := range errs {
+		if err != nil {
+			failed = append(failed, err.Error())
+		}
+	}
+	if len(failed) > 0 {
+		return "", errors.Errorf("%d of %d deliveries failed: %s", len(failed), len(p.URLs), strings.Join(failed, "; "))
+	}
+
+	return "", nil
+}
+
+func (p *HTTPFanout) post(ctx context.Context, client *http.Client, url string, data []byte, attributes map[string]string) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(data))
+	if err != nil {
+		return errors.Wrapf(err, "http.NewRequest(%s) failed", url)
+	}
+	req.Header.Set("Content-Type", p.Encoding.contentType())
+	for k, v := range attributes {
+		req.Header.Set(fmt.Sprintf("X-Alarm-%s", k), v)
+	}
+
+	res, err := client.Do(req)
+	if err != nil {
+		return errors.Wrapf(err, "POST %s failed", url)
+	}
+	res.Body.Close()
+
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		return errors.Errorf("POST %s returned %s", url, res.Status)
+	}
+	return nil
+}
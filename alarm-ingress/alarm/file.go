@@ -0,0 +1,51 @@
+package alarm
+
+import (
+	"context"
+	"os"
+	"strconv"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// FilePublisher appends every alarm as a single line to a local file. The
+// returned ID is the offset of the line within the file.
+//
+// Binary encoded alarms may contain newlines, so JSON is the only sensible
+// encoding for this publisher.
+type FilePublisher struct {
+	Path string
+
+	mu sync.Mutex
+}
+
+func (p *FilePublisher) Publish(_ context.Context, msg *Message) (string, error) {
+	data, err := EncodingJSON.marshal(msg.Alarm)
+	if err != nil {
+		return "", err
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	f, err := os.OpenFile(p.Path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return "", errors.Wrapf(err, "could not open %s", p.Path)
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return "", errors.Wrapf(err, "could not stat %s", p.Path)
+	}
+
+	if _, err := f.Write(append(data, '\n')); err != nil {
+		return "", errors.Wrapf(err, "could not write to %s", p.Path)
+	}
+	if err := f.Sync(); err != nil {
+		return "", errors.Wrapf(err, "could not sync %s", p.Path)
+	}
+
+	return strconv.FormatInt(info.Size(), 10), nil
+}
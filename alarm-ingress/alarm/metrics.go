@@ -0,0 +1,163 @@
+package alarm
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Recorder collects metrics about handled webhooks and published alarms.
+type Recorder interface {
+	// Request is called with the status code of every webhook response.
+	Request(status int)
+	// DecodeFailure is called for webhook bodies that are no valid JSON alarm.
+	DecodeFailure()
+	// Published is called after every publish attempt.
+	Published(latency time.Duration, err error)
+}
+
+// PrometheusRecorder exports the metrics for scraping.
+type PrometheusRecorder struct {
+	requests       *prometheus.CounterVec
+	decodeFailures prometheus.Counter
+	publishLatency prometheus.Histogram
+	publishErrors  prometheus.Counter
+}
+
+func NewPrometheusRecorder(registerer prometheus.Registerer) *PrometheusRecorder {
+	r := &PrometheusRecorder{
+		requests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "alarm_ingress_requests_total",
+			Help: "Webhook requests by response status code.",
+		}, []string{"code"}),
+		decodeFailures: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "alarm_ingress_decode_failures_total",
+			Help: "Webhook bodies that could not be decoded.",
+		}),
+		publishLatency: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Name:    "alarm_ingress_publish_duration_seconds",
+			Help:    "Time it took to publish an alarm.",
+			Buckets: []float64{0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10},
+		}),
+		publishErrors: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "alarm_ingress_publish_errors_total",
+			Help: "Alarms that could not be published.",
+		}),
+	}
+	registerer.MustRegister(r.requests, r.decodeFailures, r.publishLatency, r.publishErrors)
+	return r
+}
+
+func (r *PrometheusRecorder) Request(status int) {
+	r.requests.WithLabelValues(strconv.Itoa(status)).Inc()
+}
+
+func (r *PrometheusRecorder) DecodeFailure() {
+	r.decodeFailures.Inc()
+}
+
+func (r *PrometheusRecorder) Published(latency time.Duration, err error) {
+	r.publishLatency.Observe(latency.Seconds())
+	if err != nil {
+		r.publishErrors.Inc()
+	}
+}
+
+// LogRecorder writes every measurement as structured log entry, for
+// log-based metrics in Cloud Logging. The entries can be selected with
+// jsonPayload.metric and carry their values in jsonPayload.status_code and
+// jsonPayload.latency_ms.
+type LogRecorder struct{}
+
+type metricEntry struct {
+	Entry
+	Metric     string  `json:"metric"`
+	StatusCode int     `json:"status_code,omitempty"`
+	LatencyMs  float64 `json:"latency_ms,omitempty"`
+	Error      string  `json:"error,omitempty"`
+}
+
+func (e metricEntry) String() string {
+	if e.Severity == "" {
+		e.Severity = "INFO"
+	}
+	out, err := json.Marshal(e)
+	if err != nil {
+		log.Printf("json.Marshal: %v", err)
+	}
+	return string(out)
+}
+
+func (LogRecorder) Request(status int) {
+	log.Println(metricEntry{
+		Entry:      Entry{Message: "webhook request", Component: "metrics"},
+		Metric:     "alarm_ingress_request",
+		StatusCode: status,
+	})
+}
+
+func (LogRecorder) DecodeFailure() {
+	log.Println(metricEntry{
+		Entry:  Entry{Message: "webhook body could not be decoded", Component: "metrics"},
+		Metric: "alarm_ingress_decode_failure",
+	})
+}
+
+func (LogRecorder) Published(latency time.Duration, err error) {
+	e := metricEntry{
+		Entry:     Entry{Message: "alarm published", Component: "metrics"},
+		Metric:    "alarm_ingress_publish",
+		LatencyMs: float64(latency.Microseconds()) / 1000,
+	}
+	if err != nil {
+		e.Severity = "ERROR"
+		e.Message = "alarm could not be published"
+		e.Error = err.Error()
+	}
+	log.Println(e)
+}
+
+type instrumentedPublisher struct {
+	Publisher
+	metrics Recorder
+}
+
+// Instrument records the latency and errors of every publish with metrics.
+func Instrument(publisher Publisher, metrics Recorder) Publisher {
+	if metrics == nil {
+		return publisher
+	}
+	return &instrumentedPublisher{Publisher: publisher, metrics: metrics}
+}
+
+func (p *instrumentedPublisher) Publish(ctx context.Context, msg *Message) (string, error) {
+	start := time.Now()
+	id, err := p.Publisher.Publish(ctx, msg)
+	p.metrics.Published(time.Since(start), err)
+	return id, err
+}
+
+// statusWriter remembers the status code written by a handler.
+type statusWriter struct {
+	http.ResponseWriter
+	status int
+}
+
+func (w *statusWriter) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *statusWriter) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	return w.ResponseWriter.Write(b)
+}
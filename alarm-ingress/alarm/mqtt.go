@@ -0,0 +1,54 @@
+package alarm
+
+import (
+	"context"
+
+	"github.com/pkg/errors"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+)
+
+// MQTTPublisher publishes alarms to a topic on an MQTT broker.
+type MQTTPublisher struct {
+	Client   mqtt.Client
+	Topic    string
+	QoS      byte
+	Retained bool
+	Encoding Encoding
+}
+
+// NewMQTTPublisher connects to the broker described by opts.
+func NewMQTTPublisher(opts *mqtt.ClientOptions, topic string, qos byte, retained bool, encoding Encoding) (*MQTTPublisher, error) {
+	client := mqtt.NewClient(opts)
+	token := client.Connect()
+	if token.Wait() && token.Error() != nil {
+		return nil, errors.Wrap(token.Error(), "mqtt Connect() failed")
+	}
+
+	return &MQTTPublisher{
+		Client:   client,
+		Topic:    topic,
+		QoS:      qos,
+		Retained: retained,
+		Encoding: encoding,
+	}, nil
+}
+
+func (p *MQTTPublisher) Publish(ctx context.Context, msg *Message) (string, error) {
+	data, err := p.Encoding.marshal(msg.Alarm)
+	if err != nil {
+		return "", err
+	}
+
+	token := p.Client.Publish(p.Topic, p.QoS, p.Retained, data)
+	select {
+	case <-ctx.Done():
+		return "", errors.Wrap(ctx.Err(), "mqtt Publish() failed")
+	case <-token.Done():
+	}
+	if err := token.Error(); err != nil {
+		return "", errors.Wrap(err, "mqtt Publish() failed")
+	}
+
+	return "", nil
+}
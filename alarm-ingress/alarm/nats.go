@@ -0,0 +1,40 @@
+package alarm
+
+import (
+	"context"
+
+	"github.com/pkg/errors"
+
+	"github.com/nats-io/nats.go"
+)
+
+// NATSPublisher publishes alarms to a NATS subject. Message attributes are
+// sent as headers.
+type NATSPublisher struct {
+	Conn     *nats.Conn
+	Subject  string
+	Encoding Encoding
+}
+
+func (p *NATSPublisher) Publish(ctx context.Context, msg *Message) (string, error) {
+	data, err := p.Encoding.marshal(msg.Alarm)
+	if err != nil {
+		return "", err
+	}
+
+	m := nats.NewMsg(p.Subject)
+	m.Data = data
+	m.Header.Set("Content-Type", p.Encoding.contentType())
+	for k, v := range msg.Attributes {
+		m.Header.Set(k, v)
+	}
+
+	if err := p.Conn.PublishMsg(m); err != nil {
+		return "", errors.Wrap(err, "nats PublishMsg() failed")
+	}
+	if err := p.Conn.FlushWithContext(ctx); err != nil {
+		return "", errors.Wrap(err, "nats Flush() failed")
+	}
+
+	return "", nil
+}
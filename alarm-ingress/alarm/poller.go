@@ -0,0 +1,235 @@
+package alarm
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	DiveraBaseURL = "https://app.divera247.com"
+
+	// DiveraAlarmsEndpoint lists all open alarms of the unit.
+	DiveraAlarmsEndpoint = "/api/v2/alarms"
+	// DiveraLastAlarmEndpoint only returns the most recent alarm.
+	DiveraLastAlarmEndpoint = "/api/last-alarm"
+)
+
+// Poller periodically fetches alarms from the Divera REST API and publishes
+// every alarm that is new or was updated since it has last been seen. It is a
+// fallback for webhooks that never arrive. With the alarms endpoint, alarms
+// that are no longer listed were deleted or archived, a deletion is published
+// for them.
+type Poller struct {
+	BaseURL   string
+	Endpoint  string
+	AccessKey string
+	Interval  time.Duration
+	Client    *http.Client
+	Publisher Publisher
+	// Dedup is shared with the webhook handler, so alarms that arrive both
+	// ways are published only once. It is optional.
+	Dedup *Deduplicator
+
+	// alarm ID -> ts_update of the last published version
+	seen map[int64]int64
+	// mu serializes the polls of ServeHTTP
+	mu sync.Mutex
+}
+
+type diveraResponse struct {
+	Success bool            `json:"success"`
+	Message string          `json:"message"`
+	Data    json.RawMessage `json:"data"`
+}
+
+// Run polls until ctx is done. Failed polls are logged and retried with the
+// next tick.
+func (p *Poller) Run(ctx context.Context) {
+	ticker := time.NewTicker(p.Interval)
+	defer ticker.Stop()
+
+	for {
+		if n, err := p.Poll(ctx); err != nil {
+			log.Println(Entry{Severity: "ERROR", Message: fmt.Sprintf("poll failed: %s", err.Error()), Component: "poller"})
+		} else if n > 0 {
+			log.Println(Entry{Message: fmt.Sprintf("published %d alarms", n), Component: "poller"})
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Poll fetches the alarms once and publishes the ones not seen before. It
+// returns the number of published alarms.
+func (p *Poller) Poll(ctx context.Context) (int, error) {
+	if p.seen == nil {
+		p.seen = make(map[int64]int64)
+	}
+
+	alarms, err := p.fetch(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	published := 0
+	for _, alarm := range alarms {
+		if updated, ok := p.seen[alarm.ID]; ok && updated >= alarm.Updated {
+			continue
+		}
+		_, err := pushOnce(ctx, alarm, p.Publisher, p.Dedup)
+		if errors.Is(err, errDuplicate) {
+			p.seen[alarm.ID] = alarm.Updated
+			continue
+		}
+		if err != nil {
+			// not marked as seen, so it is retried with the next poll
+			return published, errors.Wrapf(err, "could not publish alarm %d", alarm.ID)
+		}
+		p.seen[alarm.ID] = alarm.Updated
+		published++
+	}
+
+	// the last-alarm endpoint drops an alarm as soon as there is a newer one
+	if alarms == nil || (p.Endpoint != "" && p.Endpoint != DiveraAlarmsEndpoint) {
+		return published, nil
+	}
+	listed := make(map[int64]bool, len(alarms))
+	for _, alarm := range alarms {
+		listed[alarm.ID] = true
+	}
+	for id := range p.seen {
+		if listed[id] {
+			continue
+		}
+		if _, err := pushDeletion(ctx, id, p.Publisher); err != nil {
+			// still seen, so it is retried with the next poll
+			return published, errors.Wrapf(err, "could not publish deletion of alarm %d", id)
+		}
+		delete(p.seen, id)
+		published++
+	}
+
+	return published, nil
+}
+
+// ServeHTTP polls once, for a scheduler that triggers the polls where Run
+// can not be used, like in a Cloud Function.
+func (p *Poller) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeProblem(w, http.StatusMethodNotAllowed, "polls must be triggered with POST")
+		return
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	n, err := p.Poll(r.Context())
+	if err != nil {
+		log.Println(Entry{Severity: "ERROR", Message: fmt.Sprintf("poll failed: %s", err.Error()), Component: "poller"})
+		writeProblem(w, http.StatusBadGateway, "poll failed")
+		return
+	}
+	if n > 0 {
+		log.Println(Entry{Message: fmt.Sprintf("published %d alarms", n), Component: "poller"})
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(struct {
+		Published int `json:"published"`
+	}{n}); err != nil {
+		log.Printf("json.Encode: %v", err)
+	}
+}
+
+func (p *Poller) fetch(ctx context.Context) ([]*jsonAlarm, error) {
+	base := p.BaseURL
+	if base == "" {
+		base = DiveraBaseURL
+	}
+	endpoint := p.Endpoint
+	if endpoint == "" {
+		endpoint = DiveraAlarmsEndpoint
+	}
+	client := p.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	u, err := url.Parse(base + endpoint)
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid Divera URL")
+	}
+	u.RawQuery = url.Values{"accesskey": {p.AccessKey}}.Encode()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return nil, errors.Wrap(err, "http.NewRequest() failed")
+	}
+	req.Header.Set("Accept", "application/json")
+
+	res, err := client.Do(req)
+	if err != nil {
+		// do not wrap the url.Error, it contains the access key
+		return nil, errors.Errorf("GET %s%s failed", base, endpoint)
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return nil, errors.Errorf("GET %s%s returned %s", base, endpoint, res.Status)
+	}
+
+	body := &diveraResponse{}
+	if err := json.NewDecoder(res.Body).Decode(body); err != nil {
+		return nil, errors.Wrap(err, "could not decode Divera response")
+	}
+	if !body.Success {
+		if endpoint == DiveraLastAlarmEndpoint {
+			// Divera reports "no alarm" as an unsuccessful response
+			return nil, nil
+		}
+		return nil, errors.Errorf("Divera returned an error: %s", body.Message)
+	}
+
+	return decodeAlarms(body.Data)
+}
+
+// decodeAlarms accepts both the item list of the alarms endpoint and the
+// single alarm of the last-alarm endpoint. A list is never nil, even if it
+// is empty.
+func decodeAlarms(data json.RawMessage) ([]*jsonAlarm, error) {
+	list := &struct {
+		Items map[string]*jsonAlarm `json:"items"`
+	}{}
+	if err := json.Unmarshal(data, list); err == nil && list.Items != nil {
+		alarms := make([]*jsonAlarm, 0, len(list.Items))
+		for _, alarm := range list.Items {
+			alarms = append(alarms, alarm)
+		}
+		// publish in the order the alarms were updated
+		sort.Slice(alarms, func(i, j int) bool { return alarms[i].Updated < alarms[j].Updated })
+		return alarms, nil
+	}
+
+	alarm := &jsonAlarm{}
+	if err := json.Unmarshal(data, alarm); err != nil {
+		return nil, errors.Wrap(err, "could not decode alarm")
+	}
+	if alarm.ID == 0 {
+		return nil, nil
+	}
+	return []*jsonAlarm{alarm}, nil
+}
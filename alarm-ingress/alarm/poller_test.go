@@ -0,0 +1,177 @@
+package alarm
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type fakePublisher struct {
+	published []*Message
+	err       error
+}
+
+func (f *fakePublisher) Publish(_ context.Context, msg *Message) (string, error) {
+	if f.err != nil {
+		return "", f.err
+	}
+	f.published = append(f.published, msg)
+	return fmt.Sprint(len(f.published)), nil
+}
+
+func fakeDivera(t *testing.T, endpoint string, body *string) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, endpoint, r.URL.Path)
+		if r.URL.Query().Get("accesskey") != "s3cr3t" {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, *body)
+	}))
+}
+
+func TestPollerAlarms(t *testing.T) {
+	body := `{"success": true, "data": {"items": {
+		"2": {"id": 2, "title": "second", "ts_create": 200, "ts_update": 200},
+		"1": {"id": 1, "title": "first", "ts_create": 100, "ts_update": 100}
+	}, "sorting": [2, 1]}}`
+	server := fakeDivera(t, DiveraAlarmsEndpoint, &body)
+	defer server.Close()
+
+	publisher := &fakePublisher{}
+	poller := &Poller{BaseURL: server.URL, AccessKey: "s3cr3t", Publisher: publisher}
+
+	n, err := poller.Poll(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, 2, n)
+	if assert.Len(t, publisher.published, 2) {
+		assert.Equal(t, int64(1), publisher.published[0].Alarm.Id)
+		assert.Equal(t, int64(2), publisher.published[1].Alarm.Id)
+	}
+
+	// nothing changed
+	n, err = poller.Poll(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, 0, n)
+
+	// alarm 2 was updated
+	body = `{"success": true, "data": {"items": {
+		"2": {"id": 2, "title": "second", "ts_create": 200, "ts_update": 250},
+		"1": {"id": 1, "title": "first", "ts_create": 100, "ts_update": 100}
+	}, "sorting": [2, 1]}}`
+	n, err = poller.Poll(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, 1, n)
+	if assert.Len(t, publisher.published, 3) {
+		assert.Equal(t, int64(2), publisher.published[2].Alarm.Id)
+		assert.Equal(t, int64(250), publisher.published[2].Alarm.Updated.Seconds)
+	}
+}
+
+func TestPollerDeletions(t *testing.T) {
+	body := `{"success": true, "data": {"items": {
+		"2": {"id": 2, "title": "second", "ts_create": 200, "ts_update": 200},
+		"1": {"id": 1, "title": "first", "ts_create": 100, "ts_update": 100}
+	}, "sorting": [2, 1]}}`
+	server := fakeDivera(t, DiveraAlarmsEndpoint, &body)
+	defer server.Close()
+
+	publisher := &fakePublisher{}
+	poller := &Poller{BaseURL: server.URL, AccessKey: "s3cr3t", Publisher: publisher}
+
+	_, err := poller.Poll(context.Background())
+	assert.NoError(t, err)
+
+	// alarm 1 was deleted, alarm 2 closed
+	body = `{"success": true, "data": {"items": {
+		"2": {"id": 2, "title": "second", "closed": true, "ts_create": 200, "ts_update": 300}
+	}, "sorting": [2]}}`
+	publisher.err = fmt.Errorf("broker down")
+	_, err = poller.Poll(context.Background())
+	assert.ErrorContains(t, err, "broker down")
+
+	// the failed deletion is retried
+	publisher.err = nil
+	n, err := poller.Poll(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, 2, n)
+	if assert.Len(t, publisher.published, 4) {
+		assert.Equal(t, int64(2), publisher.published[2].Alarm.Id)
+		assert.True(t, publisher.published[2].Alarm.Closed)
+		assert.Equal(t, int64(1), publisher.published[3].Alarm.Id)
+		assert.True(t, publisher.published[3].Alarm.Deleted)
+		assert.Equal(t, int64(0), publisher.published[3].Alarm.Updated.Seconds)
+		assert.Equal(t, "1-0", publisher.published[3].Attributes[IdempotencyKeyAttribute])
+	}
+
+	// the deletion is published once
+	n, err = poller.Poll(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, 0, n)
+}
+
+func TestPollerLastAlarm(t *testing.T) {
+	body := `{"success": false, "message": "Keine Alarmierung vorhanden"}`
+	server := fakeDivera(t, DiveraLastAlarmEndpoint, &body)
+	defer server.Close()
+
+	publisher := &fakePublisher{}
+	poller := &Poller{BaseURL: server.URL, Endpoint: DiveraLastAlarmEndpoint, AccessKey: "s3cr3t", Publisher: publisher}
+
+	n, err := poller.Poll(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, 0, n)
+
+	body = `{"success": true, "data": {"id": 7, "title": "B2", "ts_create": 100, "ts_update": 100}}`
+	publisher.err = fmt.Errorf("broker down")
+	_, err = poller.Poll(context.Background())
+	assert.ErrorContains(t, err, "broker down")
+
+	// the failed alarm is retried
+	publisher.err = nil
+	n, err = poller.Poll(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, 1, n)
+}
+
+func TestPollerHandler(t *testing.T) {
+	body := `{"success": true, "data": {"items": {
+		"1": {"id": 1, "title": "first", "ts_create": 100, "ts_update": 100}
+	}}}`
+	server := fakeDivera(t, DiveraAlarmsEndpoint, &body)
+	defer server.Close()
+
+	publisher := &fakePublisher{}
+	poller := &Poller{BaseURL: server.URL, AccessKey: "s3cr3t", Publisher: publisher}
+
+	rec := httptest.NewRecorder()
+	poller.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/", nil))
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.JSONEq(t, `{"published": 1}`, rec.Body.String())
+
+	rec = httptest.NewRecorder()
+	poller.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+	assert.Equal(t, http.StatusMethodNotAllowed, rec.Code)
+
+	publisher.err = fmt.Errorf("broker down")
+	body = `{"success": true, "data": {"items": {}}}`
+	rec = httptest.NewRecorder()
+	poller.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/", nil))
+	assert.Equal(t, http.StatusBadGateway, rec.Code)
+}
+
+func TestPollerRejected(t *testing.T) {
+	body := `{"success": true, "data": {}}`
+	server := fakeDivera(t, DiveraAlarmsEndpoint, &body)
+	defer server.Close()
+
+	poller := &Poller{BaseURL: server.URL, AccessKey: "wrong", Publisher: &fakePublisher{}}
+	_, err := poller.Poll(context.Background())
+	assert.ErrorContains(t, err, "403 Forbidden")
+	assert.NotContains(t, err.Error(), "wrong")
+}
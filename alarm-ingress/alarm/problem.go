@@ -0,0 +1,59 @@
+package alarm
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+)
+
+// problem is an RFC 7807 problem details response. It is shown in the Divera
+// webhook log, so it should tell what needs to be fixed.
+type problem struct {
+	Type   string       `json:"type"`
+	Title  string       `json:"title"`
+	Status int          `json:"status"`
+	Detail string       `json:"detail,omitempty"`
+	Errors []fieldError `json:"errors,omitempty"`
+}
+
+type fieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+// result is the response body of a successfully handled webhook.
+type result struct {
+	Status         string `json:"status"`
+	MessageID      string `json:"message_id,omitempty"`
+	IdempotencyKey string `json:"idempotency_key"`
+}
+
+func writeProblem(w http.ResponseWriter, status int, detail string, errs ...fieldError) {
+	p := problem{
+		Type:   "about:blank",
+		Title:  http.StatusText(status),
+		Status: status,
+		Detail: detail,
+		Errors: errs,
+	}
+
+	log.Println(Entry{
+		Severity: "WARNING",
+		Message:  fmt.Sprintf("Responding with %d: %s %v", status, detail, errs),
+	})
+
+	w.Header().Set("Content-Type", "application/problem+json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(p); err != nil {
+		log.Printf("json.Encode: %v", err)
+	}
+}
+
+func writeResult(w http.ResponseWriter, res result) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(res); err != nil {
+		log.Printf("json.Encode: %v", err)
+	}
+}
@@ -0,0 +1,64 @@
+package alarm
+
+import (
+	"context"
+	"strings"
+
+	"github.com/pkg/errors"
+
+	messages "github.com/CaptainStandby/divera-monitor/proto"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+)
+
+// Message is a single alarm handed to a Publisher.
+type Message struct {
+	Alarm       *messages.Alarm
+	Attributes  map[string]string
+	OrderingKey string
+}
+
+// Publisher delivers alarms to the subscribers. Publish blocks until the
+// message is delivered and returns an ID for it, if the backend provides one.
+type Publisher interface {
+	Publish(ctx context.Context, msg *Message) (string, error)
+}
+
+// Encoding selects how an alarm is serialized. The values match the
+// googclient_schemaencoding attribute set by Pub/Sub, which the daemon
+// understands.
+type Encoding string
+
+const (
+	EncodingBinary Encoding = "BINARY"
+	EncodingJSON   Encoding = "JSON"
+)
+
+// ParseEncoding parses an encoding name, an empty string selects def.
+func ParseEncoding(s string, def Encoding) (Encoding, error) {
+	switch Encoding(strings.ToUpper(s)) {
+	case "":
+		return def, nil
+	case EncodingBinary:
+		return EncodingBinary, nil
+	case EncodingJSON:
+		return EncodingJSON, nil
+	}
+	return "", errors.Errorf("unknown encoding %q", s)
+}
+
+func (e Encoding) marshal(alarm *messages.Alarm) ([]byte, error) {
+	if e == EncodingJSON {
+		data, err := protojson.Marshal(alarm)
+		return data, errors.Wrap(err, "protojson.Marshal() failed")
+	}
+	data, err := proto.Marshal(alarm)
+	return data, errors.Wrap(err, "proto.Marshal() failed")
+}
+
+func (e Encoding) contentType() string {
+	if e == EncodingJSON {
+		return "application/json"
+	}
+	return "application/x-protobuf"
+}
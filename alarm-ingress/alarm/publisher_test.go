@@ -0,0 +1,123 @@
+package alarm
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	messages "github.com/CaptainStandby/divera-monitor/proto"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+)
+
+func TestHTTPFanout(t *testing.T) {
+	alarm := &messages.Alarm{Id: 11253967, Title: "TEST TEST TEST"}
+
+	var received [][]byte
+	ok := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "application/x-protobuf", r.Header.Get("Content-Type"))
+		assert.Equal(t, "value", r.Header.Get("X-Alarm-Key"))
+		body, _ := io.ReadAll(r.Body)
+		received = append(received, body)
+	}))
+	defer ok.Close()
+
+	failing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer failing.Close()
+
+	msg := &Message{Alarm: alarm, Attributes: map[string]string{"Key": "value"}}
+
+	fanout := &HTTPFanout{URLs: []string{ok.URL}, Encoding: EncodingBinary}
+	_, err := fanout.Publish(context.Background(), msg)
+	assert.NoError(t, err)
+	if assert.Len(t, received, 1) {
+		decoded := &messages.Alarm{}
+		assert.NoError(t, proto.Unmarshal(received[0], decoded))
+		assert.True(t, proto.Equal(alarm, decoded))
+	}
+
+	fanout = &HTTPFanout{URLs: []string{ok.URL, failing.URL}, Encoding: EncodingBinary}
+	_, err = fanout.Publish(context.Background(), msg)
+	assert.ErrorContains(t, err, "1 of 2 deliveries failed")
+}
+
+func TestFilePublisher(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "alarms.jsonl")
+	publisher := &FilePublisher{Path: path}
+
+	first, err := publisher.Publish(context.Background(), &Message{Alarm: &messages.Alarm{Id: 1}})
+	assert.NoError(t, err)
+	second, err := publisher.Publish(context.Background(), &Message{Alarm: &messages.Alarm{Id: 2}})
+	assert.NoError(t, err)
+	assert.Equal(t, "0", first)
+	assert.NotEqual(t, first, second)
+
+	data, err := os.ReadFile(path)
+	assert.NoError(t, err)
+
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	if assert.Len(t, lines, 2) {
+		for i, line := range lines {
+			decoded := &messages.Alarm{}
+			assert.NoError(t, protojson.Unmarshal([]byte(line), decoded))
+			assert.Equal(t, int64(i+1), decoded.Id)
+		}
+	}
+}
+
+func TestStream(t *testing.T) {
+	stream := NewStream(2, map[string]string{"t0k3n": "station"})
+	server := httptest.NewServer(stream)
+	defer server.Close()
+
+	publish := func(id, updated int64) {
+		_, err := stream.Publish(context.Background(), &Message{Alarm: &messages.Alarm{
+			Id:      id,
+			Updated: &messages.Alarm_Timestamp{Seconds: updated},
+		}})
+		assert.NoError(t, err)
+	}
+	publish(1, 100)
+	publish(2, 200)
+	publish(3, 300)
+
+	connect := func(token, lastEventID string) *http.Response {
+		req, _ := http.NewRequest(http.MethodGet, server.URL, nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		if lastEventID != "" {
+			req.Header.Set("Last-Event-ID", lastEventID)
+		}
+		res, err := http.DefaultClient.Do(req)
+		assert.NoError(t, err)
+		return res
+	}
+
+	res := connect("wrong", "")
+	res.Body.Close()
+	assert.Equal(t, http.StatusUnauthorized, res.StatusCode)
+
+	res = connect("t0k3n", "2-200")
+	defer res.Body.Close()
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+	assert.Equal(t, "text/event-stream", res.Header.Get("Content-Type"))
+
+	publish(4, 400)
+
+	scanner := bufio.NewScanner(res.Body)
+	var ids []string
+	for len(ids) < 2 && scanner.Scan() {
+		if id, ok := strings.CutPrefix(scanner.Text(), "id: "); ok {
+			ids = append(ids, id)
+		}
+	}
+	assert.Equal(t, []string{"3-300", "4-400"}, ids)
+}
@@ -0,0 +1,38 @@
+package alarm
+
+import (
+	"context"
+
+	"github.com/pkg/errors"
+
+	"cloud.google.com/go/pubsub"
+	"google.golang.org/protobuf/proto"
+)
+
+// PubSubPublisher publishes binary encoded alarms to a Google Pub/Sub topic.
+type PubSubPublisher struct {
+	Topic *pubsub.Topic
+}
+
+func (p *PubSubPublisher) Publish(ctx context.Context, msg *Message) (string, error) {
+	data, err := proto.Marshal(msg.Alarm)
+	if err != nil {
+		return "", errors.Wrap(err, "proto.Marshal() failed")
+	}
+
+	res := p.Topic.Publish(ctx, &pubsub.Message{
+		Data:        data,
+		Attributes:  msg.Attributes,
+		OrderingKey: msg.OrderingKey,
+	})
+	id, err := res.Get(ctx)
+	if err != nil {
+		if msg.OrderingKey != "" {
+			// publishing for this key is paused after an error until resumed
+			p.Topic.ResumePublish(msg.OrderingKey)
+		}
+		return "", errors.Wrap(err, "Publish() failed")
+	}
+
+	return id, nil
+}
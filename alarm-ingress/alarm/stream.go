@@ -0,0 +1,186 @@
+package alarm
+
+import (
+	"context"
+	"crypto/subtle"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+const streamHeartbeat = 30 * time.Second
+
+type streamEvent struct {
+	id   string
+	data []byte
+}
+
+// Stream is a Publisher that pushes alarms to connected clients as
+// Server-Sent Events. Every event carries the protojson encoded alarm and an
+// ID of the form "<alarm id>-<ts_update>". Clients that reconnect with a
+// Last-Event-ID header receive all events they missed, as long as they are
+// still in the history.
+type Stream struct {
+	historySize int
+	tokens      map[string]string
+
+	mu      sync.Mutex
+	history []streamEvent
+	clients map[chan streamEvent]string
+}
+
+// NewStream creates a stream that keeps the last historySize events for
+// resuming clients. tokens maps bearer tokens to client names, only clients
+// presenting one of them are accepted.
+func NewStream(historySize int, tokens map[string]string) *Stream {
+	return &Stream{
+		historySize: historySize,
+		tokens:      tokens,
+		clients:     make(map[chan streamEvent]string),
+	}
+}
+
+func (s *Stream) Publish(_ context.Context, msg *Message) (string, error) {
+	data, err := EncodingJSON.marshal(msg.Alarm)
+	if err != nil {
+		return "", err
+	}
+	event := streamEvent{
+		id:   idempotencyKey(msg.Alarm.GetId(), msg.Alarm.GetUpdated().GetSeconds()),
+		data: data,
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.history = append(s.history, event)
+	if len(s.history) > s.historySize {
+		s.history = s.history[len(s.history)-s.historySize:]
+	}
+
+	if len(s.clients) == 0 {
+		// the event is kept in the history for clients that resume later
+		log.Println(Entry{Severity: "WARNING", Message: "no stream clients connected"})
+	}
+	for c, name := range s.clients {
+		select {
+		case c <- event:
+		default:
+			log.Println(Entry{
+				Severity: "WARNING",
+				Message:  fmt.Sprintf("stream client %s is too slow, disconnecting", name),
+			})
+			close(c)
+			delete(s.clients, c)
+		}
+	}
+
+	return event.id, nil
+}
+
+// subscribe registers a new client and returns the events it missed since
+// lastEventID.
+func (s *Stream) subscribe(name, lastEventID string) (chan streamEvent, []streamEvent) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	c := make(chan streamEvent, 16)
+	s.clients[c] = name
+
+	if lastEventID == "" {
+		return c, nil
+	}
+	for i, event := range s.history {
+		if event.id == lastEventID {
+			return c, append([]streamEvent(nil), s.history[i+1:]...)
+		}
+	}
+	// the last seen event is no longer known, send everything we have
+	return c, append([]streamEvent(nil), s.history...)
+}
+
+func (s *Stream) unsubscribe(c chan streamEvent) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.clients[c]; ok {
+		close(c)
+		delete(s.clients, c)
+	}
+}
+
+func (s *Stream) clientName(r *http.Request) (string, bool) {
+	token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if token == "" {
+		return "", false
+	}
+	for t, name := range s.tokens {
+		if subtle.ConstantTimeCompare([]byte(t), []byte(token)) == 1 {
+			return name, true
+		}
+	}
+	return "", false
+}
+
+func (s *Stream) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	name, ok := s.clientName(r)
+	if !ok {
+		log.Println(Entry{
+			Severity: "WARNING",
+			Message:  fmt.Sprintf("Rejected stream client from %s", r.RemoteAddr),
+		})
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	c, missed := s.subscribe(name, r.Header.Get("Last-Event-ID"))
+	defer s.unsubscribe(c)
+
+	log.Println(Entry{Message: fmt.Sprintf("stream client %s connected, resending %d events", name, len(missed))})
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+
+	for _, event := range missed {
+		writeEvent(w, event)
+	}
+	flusher.Flush()
+
+	heartbeat := time.NewTicker(streamHeartbeat)
+	defer heartbeat.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			log.Println(Entry{Message: fmt.Sprintf("stream client %s disconnected", name)})
+			return
+		case event, ok := <-c:
+			if !ok {
+				return
+			}
+			writeEvent(w, event)
+		case <-heartbeat.C:
+			fmt.Fprint(w, ": heartbeat\n\n")
+		}
+		flusher.Flush()
+	}
+}
+
+func writeEvent(w http.ResponseWriter, event streamEvent) {
+	fmt.Fprintf(w, "id: %s\nevent: alarm\ndata: %s\n\n", event.id, event.data)
+}
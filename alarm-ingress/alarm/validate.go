@@ -0,0 +1,91 @@
+package alarm
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math"
+	"strconv"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// maxClockSkew is how far ts_create and ts_update may be in the future.
+const maxClockSkew = time.Hour
+
+// coordinate is a latitude or longitude. Divera sends them as strings, null
+// or, depending on the API, as numbers.
+type coordinate string
+
+func (c *coordinate) UnmarshalJSON(data []byte) error {
+	data = bytes.TrimSpace(data)
+	switch {
+	case bytes.Equal(data, []byte("null")):
+		*c = ""
+	case len(data) > 0 && data[0] == '"':
+		var s string
+		if err := json.Unmarshal(data, &s); err != nil {
+			return err
+		}
+		*c = coordinate(s)
+	default:
+		var n json.Number
+		if err := json.Unmarshal(data, &n); err != nil {
+			return errors.Errorf("coordinate must be a string, number or null, not %s", data)
+		}
+		*c = coordinate(n)
+	}
+	return nil
+}
+
+// float returns 0 for a missing coordinate.
+func (c coordinate) float() (float64, error) {
+	if c == "" {
+		return 0, nil
+	}
+	return strconv.ParseFloat(string(c), 64)
+}
+
+func validateCoordinate(field string, c coordinate, limit float64) []fieldError {
+	v, err := c.float()
+	if err != nil {
+		return []fieldError{{Field: field, Message: fmt.Sprintf("%q is not a number", string(c))}}
+	}
+	// ParseFloat accepts "NaN" and "Inf", NaN would pass the range check
+	if math.IsNaN(v) || math.IsInf(v, 0) {
+		return []fieldError{{Field: field, Message: fmt.Sprintf("%q is not a number", string(c))}}
+	}
+	if v < -limit || v > limit {
+		return []fieldError{{Field: field, Message: fmt.Sprintf("%v is not within ±%v", v, limit)}}
+	}
+	return nil
+}
+
+// validate returns all problems of the alarm, it is valid if there are none.
+func validate(alarm *jsonAlarm, now time.Time) []fieldError {
+	var errs []fieldError
+
+	if alarm.ID <= 0 {
+		errs = append(errs, fieldError{Field: "id", Message: "is required"})
+	}
+
+	if alarm.Created <= 0 {
+		errs = append(errs, fieldError{Field: "ts_create", Message: "is required"})
+	} else if time.Unix(alarm.Created, 0).After(now.Add(maxClockSkew)) {
+		errs = append(errs, fieldError{Field: "ts_create", Message: "is in the future"})
+	}
+
+	if alarm.Updated <= 0 {
+		errs = append(errs, fieldError{Field: "ts_update", Message: "is required"})
+	} else if alarm.Updated < alarm.Created {
+		errs = append(errs, fieldError{Field: "ts_update", Message: "is before ts_create"})
+	} else if time.Unix(alarm.Updated, 0).After(now.Add(maxClockSkew)) {
+		errs = append(errs, fieldError{Field: "ts_update", Message: "is in the future"})
+	}
+
+	errs = append(errs, validateCoordinate("lat", alarm.Lat, 90)...)
+	errs = append(errs, validateCoordinate("lng", alarm.Lng, 180)...)
+
+	return errs
+}
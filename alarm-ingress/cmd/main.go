@@ -2,10 +2,12 @@ package main
 
 import (
 	"log"
+	"net/http"
 	"os"
 
-	// Blank-import the function package so the init() runs
-	_ "github.com/CaptainStandby/divera-monitor/alarm-ingress"
+	// Importing the function package runs its init(), which registers the
+	// Cloud Function unless running standalone.
+	function "github.com/CaptainStandby/divera-monitor/alarm-ingress"
 
 	"github.com/GoogleCloudPlatform/functions-framework-go/funcframework"
 )
@@ -16,6 +18,15 @@ func main() {
 	if envPort := os.Getenv("PORT"); envPort != "" {
 		port = envPort
 	}
+
+	if function.Standalone() {
+		log.Printf("Starting standalone server on port %s\n", port)
+		if err := http.ListenAndServe(":"+port, function.NewStandaloneHandler()); err != nil {
+			log.Fatalf("http.ListenAndServe: %v\n", err)
+		}
+		return
+	}
+
 	if err := funcframework.Start(port); err != nil {
 		log.Fatalf("funcframework.Start: %v\n", err)
 	}
@@ -4,43 +4,127 @@ import (
 	"context"
 	"log"
 	"os"
+	"strings"
+	"time"
 
 	"github.com/CaptainStandby/divera-monitor/alarm-ingress/alarm"
 	"github.com/GoogleCloudPlatform/functions-framework-go/functions"
-
-	"cloud.google.com/go/pubsub"
-	"golang.org/x/oauth2/google"
-	"google.golang.org/api/option"
 )
 
+const defaultDedupWindow = 10 * time.Minute
+
 func init() {
 	log.SetFlags(0)
 
-	projectID := os.Getenv("PROJECT_ID")
-	if projectID == "" {
-		projectID = pubsub.DetectProjectID
+	if Standalone() {
+		// cmd/main.go serves the handler itself, see NewStandaloneHandler
+		return
 	}
-	topicName := os.Getenv("TOPIC_NAME")
-	if topicName == "" {
-		log.Fatal("TOPIC_NAME environment variable is not set")
+
+	publisher := publisherFromEnv(context.Background())
+
+	// A Cloud Function can not poll by itself. PollAlarms is deployed as a
+	// function of its own, which a scheduler triggers.
+	if os.Getenv("FUNCTION_TARGET") == "PollAlarms" {
+		poller := pollerFromEnv(publisher, deduplicatorFromEnv())
+		if poller == nil {
+			log.Fatal("DIVERA_ACCESS_KEY environment variable is not set")
+		}
+		functions.HTTP("PollAlarms", poller.ServeHTTP)
+		return
 	}
 
-	ctx := context.Background()
+	functions.HTTP("HandleAlarm", alarm.BuildHandler(publisher, handlerOptionsFromEnv()...))
+}
 
-	cred, err := google.FindDefaultCredentials(ctx, pubsub.ScopePubSub)
-	if err != nil {
-		log.Fatalf("google.FindDefaultCredentials: %s", err)
+func handlerOptionsFromEnv() []alarm.Option {
+	opts := []alarm.Option{
+		alarm.WithAuthenticator(authenticatorFromEnv()),
+		alarm.WithDeduplicator(deduplicatorFromEnv()),
+	}
+	// a Cloud Function cannot be scraped, its metrics are taken from the logs
+	if os.Getenv("LOG_METRICS") != "false" {
+		opts = append(opts, alarm.WithMetrics(alarm.LogRecorder{}))
+	}
+	return opts
+}
+
+// deduplicatorFromEnv returns nil if DEDUP_WINDOW is set to 0.
+func deduplicatorFromEnv() *alarm.Deduplicator {
+	window := defaultDedupWindow
+	if val, ok := os.LookupEnv("DEDUP_WINDOW"); ok {
+		v, err := time.ParseDuration(val)
+		if err != nil || v < 0 {
+			log.Fatalf("DEDUP_WINDOW environment variable is not a valid duration")
+		}
+		window = v
+	}
+	if window == 0 {
+		return nil
 	}
 
-	client, err := pubsub.NewClient(ctx, projectID, option.WithCredentials(cred))
+	dedup, err := alarm.NewDeduplicator(window, os.Getenv("DEDUP_STATE_FILE"))
 	if err != nil {
-		log.Fatalf("pubsub.NewClient: %s", err)
+		log.Fatalf("alarm.NewDeduplicator: %s", err)
+	}
+	return dedup
+}
+
+// authenticatorFromEnv combines all configured authentication methods. At least
+// one method must be configured unless AUTH_DISABLED is set to true.
+func authenticatorFromEnv() alarm.Authenticator {
+	var auth alarm.AllOf
+
+	if secret := secretFromEnv("AUTH_HEADER_SECRET"); secret != "" {
+		auth = append(auth, alarm.HeaderSecret{
+			Header: envOrDefault("AUTH_HEADER_NAME", "X-Divera-Secret"),
+			Secret: secret,
+		})
+	}
+	if token := secretFromEnv("AUTH_ACCESS_TOKEN"); token != "" {
+		auth = append(auth, alarm.QueryToken{
+			Param: envOrDefault("AUTH_ACCESS_TOKEN_PARAM", "accesskey"),
+			Token: token,
+		})
+	}
+	if key := secretFromEnv("AUTH_HMAC_KEY"); key != "" {
+		auth = append(auth, alarm.HMACSignature{
+			Header: envOrDefault("AUTH_HMAC_HEADER", "X-Divera-Signature"),
+			Key:    []byte(key),
+		})
 	}
 
-	topic := client.Topic(topicName)
-	if topic == nil {
-		log.Fatalf("client.Topic(%s) returned nil", topicName)
+	if len(auth) == 0 {
+		if os.Getenv("AUTH_DISABLED") != "true" {
+			log.Fatal("no authentication configured, set AUTH_HEADER_SECRET, AUTH_ACCESS_TOKEN or AUTH_HMAC_KEY (or AUTH_DISABLED=true)")
+		}
+		log.Println(alarm.Entry{Severity: "WARNING", Message: "webhook authentication is disabled"})
+		return nil
 	}
 
-	functions.HTTP("HandleAlarm", alarm.BuildHandler(topic.Publish))
+	return auth
+}
+
+// secretFromEnv reads a secret from the environment variable name or from the
+// file referenced by name_FILE.
+func secretFromEnv(name string) string {
+	if val := os.Getenv(name); val != "" {
+		return val
+	}
+	file := os.Getenv(name + "_FILE")
+	if file == "" {
+		return ""
+	}
+	data, err := os.ReadFile(file)
+	if err != nil {
+		log.Fatalf("could not read %s_FILE: %s", name, err)
+	}
+	return strings.TrimSpace(string(data))
+}
+
+func envOrDefault(name, def string) string {
+	if val := os.Getenv(name); val != "" {
+		return val
+	}
+	return def
 }
@@ -0,0 +1,145 @@
+package function
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/CaptainStandby/divera-monitor/alarm-ingress/alarm"
+
+	"cloud.google.com/go/pubsub"
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+	"github.com/nats-io/nats.go"
+	"golang.org/x/oauth2/google"
+	"google.golang.org/api/option"
+)
+
+// publisherFromEnv creates the backend selected by the PUBLISHER environment
+// variable. Pub/Sub is used if it is not set.
+func publisherFromEnv(ctx context.Context) alarm.Publisher {
+	kind := envOrDefault("PUBLISHER", "pubsub")
+
+	switch kind {
+	case "pubsub":
+		return pubSubPublisherFromEnv(ctx)
+	case "mqtt":
+		return mqttPublisherFromEnv()
+	case "nats":
+		return natsPublisherFromEnv()
+	case "http":
+		return httpFanoutFromEnv()
+	case "file":
+		return filePublisherFromEnv()
+	}
+
+	log.Fatalf("unknown PUBLISHER %q, expected one of pubsub, mqtt, nats, http or file", kind)
+	return nil
+}
+
+func pubSubPublisherFromEnv(ctx context.Context) alarm.Publisher {
+	projectID := os.Getenv("PROJECT_ID")
+	if projectID == "" {
+		projectID = pubsub.DetectProjectID
+	}
+	topicName := requireEnv("TOPIC_NAME")
+
+	cred, err := google.FindDefaultCredentials(ctx, pubsub.ScopePubSub)
+	if err != nil {
+		log.Fatalf("google.FindDefaultCredentials: %s", err)
+	}
+
+	client, err := pubsub.NewClient(ctx, projectID, option.WithCredentials(cred))
+	if err != nil {
+		log.Fatalf("pubsub.NewClient: %s", err)
+	}
+
+	topic := client.Topic(topicName)
+	if topic == nil {
+		log.Fatalf("client.Topic(%s) returned nil", topicName)
+	}
+	// alarms are published with the alarm ID as ordering key
+	topic.EnableMessageOrdering = true
+
+	return &alarm.PubSubPublisher{Topic: topic}
+}
+
+func mqttPublisherFromEnv() alarm.Publisher {
+	opts := mqtt.NewClientOptions().
+		AddBroker(requireEnv("MQTT_BROKER")).
+		SetClientID(envOrDefault("MQTT_CLIENT_ID", "alarm-ingress")).
+		SetUsername(os.Getenv("MQTT_USERNAME")).
+		SetPassword(secretFromEnv("MQTT_PASSWORD")).
+		SetConnectTimeout(10 * time.Second)
+
+	qos, err := strconv.ParseUint(envOrDefault("MQTT_QOS", "1"), 10, 8)
+	if err != nil || qos > 2 {
+		log.Fatalf("MQTT_QOS must be 0, 1 or 2")
+	}
+	retained := os.Getenv("MQTT_RETAIN") == "true"
+
+	publisher, err := alarm.NewMQTTPublisher(opts, requireEnv("MQTT_TOPIC"), byte(qos), retained, encodingFromEnv(alarm.EncodingBinary))
+	if err != nil {
+		log.Fatalf("alarm.NewMQTTPublisher: %s", err)
+	}
+	return publisher
+}
+
+func natsPublisherFromEnv() alarm.Publisher {
+	var opts []nats.Option
+	if token := secretFromEnv("NATS_TOKEN"); token != "" {
+		opts = append(opts, nats.Token(token))
+	}
+	if creds := os.Getenv("NATS_CREDS_FILE"); creds != "" {
+		opts = append(opts, nats.UserCredentials(creds))
+	}
+
+	conn, err := nats.Connect(envOrDefault("NATS_URL", nats.DefaultURL), opts...)
+	if err != nil {
+		log.Fatalf("nats.Connect: %s", err)
+	}
+
+	return &alarm.NATSPublisher{
+		Conn:     conn,
+		Subject:  requireEnv("NATS_SUBJECT"),
+		Encoding: encodingFromEnv(alarm.EncodingBinary),
+	}
+}
+
+func httpFanoutFromEnv() alarm.Publisher {
+	var urls []string
+	for _, url := range strings.Split(requireEnv("HTTP_TARGETS"), ",") {
+		if url = strings.TrimSpace(url); url != "" {
+			urls = append(urls, url)
+		}
+	}
+
+	return &alarm.HTTPFanout{
+		Client:   &http.Client{Timeout: 10 * time.Second},
+		URLs:     urls,
+		Encoding: encodingFromEnv(alarm.EncodingJSON),
+	}
+}
+
+func filePublisherFromEnv() alarm.Publisher {
+	return &alarm.FilePublisher{Path: requireEnv("ALARM_FILE")}
+}
+
+func encodingFromEnv(def alarm.Encoding) alarm.Encoding {
+	encoding, err := alarm.ParseEncoding(os.Getenv("PUBLISH_ENCODING"), def)
+	if err != nil {
+		log.Fatalf("PUBLISH_ENCODING: %s", err)
+	}
+	return encoding
+}
+
+func requireEnv(name string) string {
+	val := os.Getenv(name)
+	if val == "" {
+		log.Fatalf("%s environment variable is not set", name)
+	}
+	return val
+}
@@ -0,0 +1,120 @@
+package function
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/CaptainStandby/divera-monitor/alarm-ingress/alarm"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const (
+	defaultStreamHistory = 100
+	defaultPollInterval  = 30 * time.Second
+)
+
+// Standalone reports whether the ingress runs as a self-hosted server that
+// streams alarms to the daemons directly instead of as a Cloud Function.
+func Standalone() bool {
+	return os.Getenv("MODE") == "standalone"
+}
+
+// NewStandaloneHandler serves the Divera webhook on /alarm, the alarm stream
+// for the daemons on /stream and Prometheus metrics on /metrics. If
+// DIVERA_ACCESS_KEY is set, alarms are additionally polled from the Divera API.
+func NewStandaloneHandler() http.Handler {
+	history := defaultStreamHistory
+	if val, ok := os.LookupEnv("STREAM_HISTORY"); ok {
+		v, err := strconv.Atoi(val)
+		if err != nil || v < 1 {
+			log.Fatalf("STREAM_HISTORY must be a positive number")
+		}
+		history = v
+	}
+
+	stream := alarm.NewStream(history, streamTokensFromEnv())
+	dedup := deduplicatorFromEnv()
+	metrics := alarm.NewPrometheusRecorder(prometheus.DefaultRegisterer)
+
+	if poller := pollerFromEnv(alarm.Instrument(stream, metrics), dedup); poller != nil {
+		go poller.Run(context.Background())
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("/alarm", alarm.BuildHandler(stream,
+		alarm.WithAuthenticator(authenticatorFromEnv()),
+		alarm.WithDeduplicator(dedup),
+		alarm.WithMetrics(metrics),
+	))
+	mux.Handle("/stream", stream)
+	mux.Handle("/metrics", promhttp.Handler())
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+
+	return mux
+}
+
+// streamTokensFromEnv parses STREAM_TOKENS, a comma separated list of
+// name:token pairs, one for every daemon that may connect.
+func streamTokensFromEnv() map[string]string {
+	tokens := make(map[string]string)
+	for i, pair := range strings.Split(secretFromEnv("STREAM_TOKENS"), ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		name, token, ok := strings.Cut(pair, ":")
+		if !ok || name == "" || token == "" {
+			log.Fatalf("STREAM_TOKENS entry %d is not of the form name:token", i+1)
+		}
+		tokens[token] = name
+	}
+
+	if len(tokens) == 0 {
+		log.Fatal("STREAM_TOKENS environment variable is not set")
+	}
+	return tokens
+}
+
+// pollerFromEnv returns nil if polling is not configured.
+func pollerFromEnv(publisher alarm.Publisher, dedup *alarm.Deduplicator) *alarm.Poller {
+	accessKey := secretFromEnv("DIVERA_ACCESS_KEY")
+	if accessKey == "" {
+		return nil
+	}
+
+	interval := defaultPollInterval
+	if val, ok := os.LookupEnv("DIVERA_POLL_INTERVAL"); ok {
+		v, err := time.ParseDuration(val)
+		if err != nil || v <= 0 {
+			log.Fatalf("DIVERA_POLL_INTERVAL environment variable is not a valid duration")
+		}
+		interval = v
+	}
+
+	endpoint := alarm.DiveraAlarmsEndpoint
+	switch val := os.Getenv("DIVERA_ENDPOINT"); val {
+	case "", "alarms":
+	case "last-alarm":
+		endpoint = alarm.DiveraLastAlarmEndpoint
+	default:
+		log.Fatalf("DIVERA_ENDPOINT must be either alarms or last-alarm, not %s", val)
+	}
+
+	return &alarm.Poller{
+		BaseURL:   envOrDefault("DIVERA_BASE_URL", alarm.DiveraBaseURL),
+		Endpoint:  endpoint,
+		AccessKey: accessKey,
+		Interval:  interval,
+		Client:    &http.Client{Timeout: 15 * time.Second},
+		Publisher: publisher,
+		Dedup:     dedup,
+	}
+}
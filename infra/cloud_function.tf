@@ -4,7 +4,8 @@ locals {
     "cloudfunctions.googleapis.com",
     "run.googleapis.com",
     "artifactregistry.googleapis.com",
-    "cloudbuild.googleapis.com"
+    "cloudbuild.googleapis.com",
+    "cloudscheduler.googleapis.com"
   ]
 }
 
@@ -55,6 +56,8 @@ resource "google_cloudfunctions2_function" "alarm_ingress" {
     environment_variables = {
       PROJECT_ID = data.google_project.project.project_id
       TOPIC_NAME = google_pubsub_topic.divera_alarm.name
+
+      AUTH_ACCESS_TOKEN = var.webhook_access_token
     }
   }
 }
@@ -1,12 +1,13 @@
 
+# .build/alarm-ingress is created by "make function-source"
 data "archive_file" "alarm_ingress_source" {
   type        = "zip"
   output_path = "${path.module}/.tmp/alarm-ingress.zip"
-  source_dir  = "${path.module}/../alarm-ingress"
-  excludes = [
-    "cmd",
-    "alarm_test.go"
-  ]
+  source_dir  = "${path.module}/.build/alarm-ingress"
+  excludes = setunion(
+    ["cmd"],
+    fileset("${path.module}/.build/alarm-ingress", "**/*_test.go")
+  )
 }
 
 resource "google_storage_bucket" "bucket" {
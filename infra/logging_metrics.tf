@@ -0,0 +1,62 @@
+# The function writes its metrics as structured log entries, see
+# alarm.LogRecorder. These log-based metrics turn them into time series.
+
+locals {
+  ingress_log_filter = "resource.type=\"cloud_run_revision\" AND resource.labels.service_name=\"${google_cloudfunctions2_function.alarm_ingress.name}\""
+}
+
+resource "google_logging_metric" "ingress_requests" {
+  name   = "alarm_ingress/requests"
+  filter = "${local.ingress_log_filter} AND jsonPayload.metric=\"alarm_ingress_request\""
+
+  metric_descriptor {
+    metric_kind = "DELTA"
+    value_type  = "INT64"
+    labels {
+      key        = "status_code"
+      value_type = "STRING"
+    }
+  }
+  label_extractors = {
+    status_code = "EXTRACT(jsonPayload.status_code)"
+  }
+}
+
+resource "google_logging_metric" "ingress_decode_failures" {
+  name   = "alarm_ingress/decode_failures"
+  filter = "${local.ingress_log_filter} AND jsonPayload.metric=\"alarm_ingress_decode_failure\""
+
+  metric_descriptor {
+    metric_kind = "DELTA"
+    value_type  = "INT64"
+  }
+}
+
+resource "google_logging_metric" "ingress_publish_errors" {
+  name   = "alarm_ingress/publish_errors"
+  filter = "${local.ingress_log_filter} AND jsonPayload.metric=\"alarm_ingress_publish\" AND severity=ERROR"
+
+  metric_descriptor {
+    metric_kind = "DELTA"
+    value_type  = "INT64"
+  }
+}
+
+resource "google_logging_metric" "ingress_publish_latency" {
+  name            = "alarm_ingress/publish_latency"
+  filter          = "${local.ingress_log_filter} AND jsonPayload.metric=\"alarm_ingress_publish\""
+  value_extractor = "EXTRACT(jsonPayload.latency_ms)"
+
+  metric_descriptor {
+    metric_kind = "DELTA"
+    value_type  = "DISTRIBUTION"
+    unit        = "ms"
+  }
+  bucket_options {
+    exponential_buckets {
+      num_finite_buckets = 16
+      growth_factor      = 2
+      scale              = 1
+    }
+  }
+}
@@ -0,0 +1,72 @@
+resource "google_cloudfunctions2_function" "alarm_poller" {
+  count      = var.poll_enabled ? 1 : 0
+  depends_on = [google_project_service.services]
+  name       = "alarm-poller"
+  location   = local.region
+
+  build_config {
+    runtime     = "go122"
+    entry_point = "PollAlarms"
+    source {
+      storage_source {
+        bucket = google_storage_bucket.bucket.name
+        object = google_storage_bucket_object.alarm_ingress_source.name
+      }
+    }
+  }
+
+  service_config {
+    # the alarms seen so far and the duplicate filter are kept in memory. One
+    # instance that is always kept keeps them, otherwise every cold start
+    # publishes all listed alarms again and misses the deletions before it.
+    max_instance_count               = 1
+    min_instance_count               = 1
+    available_memory                 = "256M"
+    timeout_seconds                  = 30
+    max_instance_request_concurrency = 1
+    available_cpu                    = "1"
+    ingress_settings                 = "ALLOW_ALL"
+    all_traffic_on_latest_revision   = true
+    service_account_email            = google_service_account.publisher.email
+    environment_variables = {
+      PROJECT_ID = data.google_project.project.project_id
+      TOPIC_NAME = google_pubsub_topic.divera_alarm.name
+
+      DIVERA_ACCESS_KEY = var.divera_access_key
+    }
+  }
+}
+
+resource "google_service_account" "scheduler" {
+  count        = var.poll_enabled ? 1 : 0
+  account_id   = "alarm-poll-scheduler-sa"
+  display_name = "Divera Alarm Poll Scheduler Service Account"
+}
+
+# only the scheduler may trigger polls
+resource "google_cloud_run_service_iam_binding" "poller_invoker" {
+  count    = var.poll_enabled ? 1 : 0
+  project  = google_cloudfunctions2_function.alarm_poller[0].project
+  location = google_cloudfunctions2_function.alarm_poller[0].location
+  service  = google_cloudfunctions2_function.alarm_poller[0].name
+  role     = "roles/run.invoker"
+  members  = [google_service_account.scheduler[0].member]
+}
+
+resource "google_cloud_scheduler_job" "alarm_poll" {
+  count      = var.poll_enabled ? 1 : 0
+  depends_on = [google_project_service.services]
+  name       = "alarm-poll"
+  region     = local.region
+  schedule   = "* * * * *"
+
+  http_target {
+    http_method = "POST"
+    uri         = google_cloudfunctions2_function.alarm_poller[0].url
+
+    oidc_token {
+      service_account_email = google_service_account.scheduler[0].email
+      audience              = google_cloudfunctions2_function.alarm_poller[0].url
+    }
+  }
+}
@@ -1,3 +1,22 @@
 variable "subscriber_public_key" {
   type = string
 }
+
+# Passed by the Makefile from .build/webhook_access_token, which it generates.
+variable "webhook_access_token" {
+  type      = string
+  sensitive = true
+}
+
+# Polls the Divera API every minute as a fallback for missed webhooks. It
+# also publishes the deletion of alarms.
+variable "poll_enabled" {
+  type    = bool
+  default = false
+}
+
+variable "divera_access_key" {
+  type      = string
+  sensitive = true
+  default   = ""
+}